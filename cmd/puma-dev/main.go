@@ -6,6 +6,8 @@ import (
 	"os"
 	"runtime"
 	"strings"
+
+	"github.com/puma/puma-dev/dev"
 )
 
 var (
@@ -56,7 +58,11 @@ func execWithExitStatus() CommandResult {
 	return Continue
 }
 
+var fLogMaxSize = dev.ByteSize(dev.DefaultLogMaxSize)
+
 func init() {
+	flag.Var(&fLogMaxSize, "log-max-size", "how big an app's log file gets before it is rotated")
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
@@ -16,17 +16,27 @@ import (
 )
 
 var (
-	fDebug    = flag.Bool("debug", false, "enable debug output")
-	fDomains  = flag.String("d", "test", "domains to handle, separate with :, defaults to test")
-	fDNSPort  = flag.Int("dns-port", 9253, "port to listen on dns for")
-	fHTTPPort = flag.Int("http-port", 9280, "port to listen on http for")
-	fTLSPort  = flag.Int("https-port", 9283, "port to listen on https for")
-	fDir      = flag.String("dir", "~/.puma-dev", "directory to watch for apps")
-	fTimeout  = flag.Duration("timeout", 15*60*time.Second, "how long to let an app idle for")
-	fPow      = flag.Bool("pow", false, "Mimic pow's settings")
-	fLaunch   = flag.Bool("launchd", false, "Use socket from launchd")
+	fDebug        = flag.Bool("debug", false, "enable debug output")
+	fDomains      = flag.String("d", "test", "domains to handle, separate with :, defaults to test")
+	fDNSPort      = flag.Int("dns-port", 9253, "port to listen on dns for")
+	fHTTPPort     = flag.Int("http-port", 9280, "port to listen on http for")
+	fTLSPort      = flag.Int("https-port", 9283, "port to listen on https for")
+	fDir          = flag.String("dir", "~/.puma-dev", "directory to watch for apps")
+	fTimeout      = flag.Duration("timeout", 15*60*time.Second, "how long to let an app idle for")
+	fBootTimeout  = flag.Duration("boot-timeout", 5*60*time.Second, "how long to wait for an app to boot, 0 to wait forever")
+	fStopTimeout  = flag.Duration("stop-timeout", 10*time.Second, "how long to let an app shut down before killing it")
+	fMaxRunning   = flag.Int("max-running", 0, "how many apps to keep running at once, 0 for no limit")
+	fSocketDir    = flag.String("socket-dir", "", "directory for app sockets, or runtime for $XDG_RUNTIME_DIR/puma-dev, defaults to each app's tmp dir")
+	fPortRange    = flag.String("port-range", "", "loopback ports for apps that listen on tcp, such as 9300-9399, defaults to any free port")
+	fLogDir       = flag.String("log-dir", "~/.puma-dev/logs", "directory to write app logs to, empty to only keep them in memory")
+	fLogKeep      = flag.Int("log-keep", dev.DefaultLogKeep, "how many rotated log files to keep per app")
+	fCacheEnv     = flag.Bool("cache-env", false, "cache the environment set up by the login shell and app env files to boot apps faster")
+	fGitWorktrees = flag.Bool("git-worktrees", false, "serve <branch>.<app> from a git worktree of the app's branch")
+	fPow          = flag.Bool("pow", false, "Mimic pow's settings")
+	fLaunch       = flag.Bool("launchd", false, "Use socket from launchd")
 
 	fNoServePublicPaths = flag.String("no-serve-public-paths", "", "Disable static file server for specific paths under /public")
+	fPrewarm            = flag.String("prewarm", "", "apps to boot at startup and keep running, separate with :")
 
 	fSetup = flag.Bool("setup", false, "Run system setup")
 	fStop  = flag.Bool("stop", false, "Stop all puma-dev servers")
@@ -122,8 +132,49 @@ func main() {
 	var pool dev.AppPool
 	pool.Dir = dir
 	pool.IdleTime = *fTimeout
+	pool.BootTimeout = *fBootTimeout
+	pool.StopTimeout = *fStopTimeout
+	pool.MaxRunning = *fMaxRunning
+	pool.CacheEnv = *fCacheEnv
+	pool.GitWorktrees = *fGitWorktrees
+	if len(*fPrewarm) > 0 {
+		pool.Prewarm = strings.Split(*fPrewarm, ":")
+	}
 	pool.Events = &events
 
+	if *fLogDir != "" {
+		pool.LogDir, err = homedir.Expand(*fLogDir)
+		if err != nil {
+			log.Fatalf("Unable to expand log dir: %s", err)
+		}
+
+		pool.LogMaxSize = fLogMaxSize
+		pool.LogKeep = *fLogKeep
+
+		fmt.Printf("* Directory for app logs: %s\n", pool.LogDir)
+	}
+
+	if *fPortRange != "" {
+		pool.MinPort, pool.MaxPort, err = dev.ParsePortRange(*fPortRange)
+		if err != nil {
+			log.Fatalf("Unable to use -port-range: %s", err)
+		}
+	}
+
+	if *fSocketDir != "" {
+		socketDir := *fSocketDir
+		if socketDir == "runtime" {
+			socketDir = dev.RuntimeSocketDir()
+		}
+
+		err = pool.SetupSocketDir(socketDir)
+		if err != nil {
+			log.Fatalf("Unable to create socket dir '%s': %s", socketDir, err)
+		}
+
+		fmt.Printf("* Directory for sockets: %s\n", socketDir)
+	}
+
 	purge := make(chan os.Signal, 1)
 
 	signal.Notify(purge, syscall.SIGUSR1)
@@ -132,6 +183,7 @@ func main() {
 		for {
 			<-purge
 			pool.Purge()
+			pool.Warm()
 		}
 	}()
 
@@ -201,6 +253,8 @@ func main() {
 
 	fmt.Printf("! Puma dev running...\n")
 
+	go pool.Warm()
+
 	go func() {
 		if err := http.ServeTLS(tlsSocketName); err != nil {
 			fmt.Printf("! HTTPS Server failed: %v\n", err)
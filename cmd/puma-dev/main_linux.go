@@ -21,9 +21,21 @@ var (
 	fDomains            = flag.String("d", "test", "domains to handle, separate with :, defaults to test")
 	fHTTPPort           = flag.Int("http-port", 9280, "port to listen on http for")
 	fNoServePublicPaths = flag.String("no-serve-public-paths", "", "Disable static file server for specific paths under /public")
+	fPrewarm            = flag.String("prewarm", "", "apps to boot at startup and keep running, separate with :")
 	fStop               = flag.Bool("stop", false, "Stop all puma-dev servers")
 	fSysBind            = flag.Bool("sysbind", false, "bind to ports 80 and 443")
 	fTimeout            = flag.Duration("timeout", 15*60*time.Second, "how long to let an app idle for")
+	fBootTimeout        = flag.Duration("boot-timeout", 5*60*time.Second, "how long to wait for an app to boot, 0 to wait forever")
+	fStopTimeout        = flag.Duration("stop-timeout", 10*time.Second, "how long to let an app shut down before killing it")
+	fMaxRunning         = flag.Int("max-running", 0, "how many apps to keep running at once, 0 for no limit")
+	fSocketDir          = flag.String("socket-dir", "", "directory for app sockets, or runtime for $XDG_RUNTIME_DIR/puma-dev, defaults to each app's tmp dir")
+	fPortRange          = flag.String("port-range", "", "loopback ports for apps that listen on tcp, such as 9300-9399, defaults to any free port")
+	fLogDir             = flag.String("log-dir", "~/.puma-dev/logs", "directory to write app logs to, empty to only keep them in memory")
+	fLogKeep            = flag.Int("log-keep", dev.DefaultLogKeep, "how many rotated log files to keep per app")
+	fCacheEnv           = flag.Bool("cache-env", false, "cache the environment set up by the login shell and app env files to boot apps faster")
+	fGitWorktrees       = flag.Bool("git-worktrees", false, "serve <branch>.<app> from a git worktree of the app's branch")
+	fCgroups            = flag.Bool("cgroups", false, "put each app in its own cgroup v2 group with the limits from its config")
+	fCgroupRoot         = flag.String("cgroup-root", "", "cgroup to create app groups in, defaults to puma-dev under the user's systemd service")
 	fTLSPort            = flag.Int("https-port", 9283, "port to listen on https for")
 )
 
@@ -63,8 +75,67 @@ func main() {
 	var pool dev.AppPool
 	pool.Dir = dir
 	pool.IdleTime = *fTimeout
+	pool.BootTimeout = *fBootTimeout
+	pool.StopTimeout = *fStopTimeout
+	pool.MaxRunning = *fMaxRunning
+	pool.CacheEnv = *fCacheEnv
+	pool.GitWorktrees = *fGitWorktrees
+
+	if *fCgroups {
+		root := *fCgroupRoot
+		if root == "" {
+			root, err = dev.DelegatedCgroup()
+		}
+
+		if err == nil {
+			err = pool.SetupCgroups(root)
+		}
+
+		if err != nil {
+			fmt.Printf("! Unable to set up cgroups, apps will run without limits: %s\n", err)
+		} else {
+			fmt.Printf("* Cgroup for apps: %s\n", root)
+		}
+	}
+
+	if len(*fPrewarm) > 0 {
+		pool.Prewarm = strings.Split(*fPrewarm, ":")
+	}
 	pool.Events = &events
 
+	if *fLogDir != "" {
+		pool.LogDir, err = homedir.Expand(*fLogDir)
+		if err != nil {
+			log.Fatalf("Unable to expand log dir: %s", err)
+		}
+
+		pool.LogMaxSize = fLogMaxSize
+		pool.LogKeep = *fLogKeep
+
+		fmt.Printf("* Directory for app logs: %s\n", pool.LogDir)
+	}
+
+	if *fPortRange != "" {
+		pool.MinPort, pool.MaxPort, err = dev.ParsePortRange(*fPortRange)
+		if err != nil {
+			log.Fatalf("Unable to use -port-range: %s", err)
+		}
+	}
+
+	if *fSocketDir != "" {
+		socketDir := *fSocketDir
+		if socketDir == "runtime" {
+			socketDir = dev.RuntimeSocketDir()
+		}
+
+		err = pool.SetupSocketDir(socketDir)
+		if err != nil {
+			log.Fatalf("Unable to create socket dir '%s': %s", socketDir, err)
+		}
+
+		fmt.Printf("* Directory for sockets: %s\n", socketDir)
+	}
+
 	purge := make(chan os.Signal, 1)
 	signal.Notify(purge, syscall.SIGUSR1)
 
@@ -72,6 +143,7 @@ func main() {
 		for {
 			<-purge
 			pool.Purge()
+			pool.Warm()
 		}
 	}()
 
@@ -112,6 +184,8 @@ func main() {
 
 	fmt.Printf("! Puma dev listening on http and https\n")
 
+	go pool.Warm()
+
 	go http.ServeTLS()
 
 	err = http.Serve()
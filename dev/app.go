@@ -7,8 +7,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -28,6 +26,8 @@ const DefaultThreads = 5
 
 var ErrUnexpectedExit = errors.New("unexpected exit")
 
+var ErrBootTimeout = errors.New("app did not boot in time")
+
 type App struct {
 	Name    string
 	Scheme  string
@@ -36,6 +36,7 @@ type App struct {
 	Command *exec.Cmd
 	Public  bool
 	Events  *Events
+	Config  *AppConfig
 
 	lines       linebuffer.LineBuffer
 	lastLogLine string
@@ -43,17 +44,54 @@ type App struct {
 	address string
 	dir     string
 
+	launchCommand string
+	exitCode      int
+
+	// profile is the per-app config profile a variant runs with.
+	profile string
+
+	// proxy serves requests for proxy files with their own settings.
+	proxy *balancer
+
 	t tomb.Tomb
 
 	stdout  io.Reader
 	pool    *AppPool
 	lastUse time.Time
+	started time.Time
 
 	lock sync.Mutex
 
-	booting bool
+	booting    bool
+	stopping   bool
+	restarting bool
+
+	// restartOutput gets the app's output lines during a phased or hot
+	// restart. It is protected by lock, as is pumaBoot.
+	restartOutput chan string
+	pumaBoot      pumaBoot
+
+	inflight int64
 
 	readyChan chan struct{}
+	readyAt   time.Time
+
+	usage   *AppUsage
+	cgroup  *cgroup
+	logFile *logFile
+
+	// sidecars is protected by lock. A new instance booted for a blue/green
+	// restart leaves starting them to pendingSidecars, so they don't clash
+	// with the old instance's.
+	sidecars        []*sidecar
+	pendingSidecars func()
+	sidecarsStopped sync.Once
+
+	// env is the environment the app was started with, once it's known.
+	// It is protected by lock.
+	env []string
+
+	stopped chan struct{}
 }
 
 func (a *App) eventAdd(name string, args ...interface{}) {
@@ -61,6 +99,7 @@ func (a *App) eventAdd(name string, args ...interface{}) {
 
 	str := a.Events.Add(name, args...)
 	a.lines.Append("#event " + str)
+	a.logFile.WriteLine(os.Getpid(), "#event "+str)
 }
 
 func (a *App) SetAddress(scheme, host string, port int) {
@@ -83,17 +122,32 @@ func (a *App) Address() string {
 	return fmt.Sprintf("%s:%d", a.Host, a.Port)
 }
 
+// Kill asks the app's whole process group to stop with SIGTERM. If anything
+// in the group is still running once the app's stop timeout is up, it gets
+// SIGKILL.
 func (a *App) Kill(reason string) error {
-	a.eventAdd("killing_app",
-		"pid", a.Command.Process.Pid,
+	return a.kill(reason)
+}
+
+// kill is Kill with extra arguments for the killing_app event.
+func (a *App) kill(reason string, args ...interface{}) error {
+	a.lock.Lock()
+	alreadyStopping := a.stopping
+	a.stopping = true
+	a.lock.Unlock()
+
+	pid := a.Command.Process.Pid
+
+	a.eventAdd("killing_app", append([]interface{}{
+		"pid", pid,
 		"reason", reason,
-	)
+	}, args...)...)
 
-	fmt.Printf("! Killing '%s' (%d) - '%s'\n", a.Name, a.Command.Process.Pid, reason)
-	err := a.Command.Process.Signal(syscall.SIGTERM)
+	fmt.Printf("! Killing '%s' (%d) - '%s'\n", a.Name, pid, reason)
+	err := syscall.Kill(-pid, syscall.SIGTERM)
 	if err != nil {
 		a.eventAdd("killing_error",
-			"pid", a.Command.Process.Pid,
+			"pid", pid,
 			"error", err.Error(),
 		)
 		fmt.Printf("! Error trying to kill %s: %s", a.Name, err)
@@ -104,9 +158,58 @@ func (a *App) Kill(reason string) error {
 		a.eventAdd("shutdown")
 	}
 
+	if !alreadyStopping {
+		go a.escalateStop()
+	}
+
 	return err
 }
 
+// escalateStop waits for the app's process group to exit after being sent
+// SIGTERM, and sends SIGKILL to anything left in it once the stop timeout is
+// up. It closes a.stopped when the group is gone.
+func (a *App) escalateStop() {
+	defer close(a.stopped)
+
+	pgid := a.Command.Process.Pid
+	timeout := a.Config.StopTimeout.Duration
+	start := time.Now()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if syscall.Kill(-pgid, 0) == syscall.ESRCH {
+			a.eventAdd("app_stopped",
+				"pid", pgid,
+				"took", time.Since(start).String(),
+			)
+			return
+		}
+
+		if time.Since(start) > timeout {
+			a.eventAdd("stop_escalated",
+				"pid", pgid,
+				"signal", "SIGKILL",
+				"stop_timeout", timeout.String(),
+			)
+
+			fmt.Printf("! App '%s' still running after %s, sending SIGKILL\n", a.Name, timeout)
+
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			if err != nil && err != syscall.ESRCH {
+				a.eventAdd("killing_error",
+					"pid", pgid,
+					"error", err.Error(),
+				)
+			}
+			return
+		}
+
+		<-ticker.C
+	}
+}
+
 func (a *App) watch() error {
 	c := make(chan error)
 
@@ -117,8 +220,11 @@ func (a *App) watch() error {
 			line, err := r.ReadString('\n')
 			if line != "" {
 				a.lines.Append(line)
+				a.logFile.WriteLine(a.Command.Process.Pid, line)
 				a.lastLogLine = line
 				fmt.Fprintf(os.Stdout, "%s[%d]: %s", a.Name, a.Command.Process.Pid, line)
+
+				a.restartLine(line)
 			}
 
 			if err != nil {
@@ -132,37 +238,69 @@ func (a *App) watch() error {
 
 	reason := "detected interval shutdown"
 
+	crashed := false
+
 	select {
 	case err = <-c:
 		reason = "stdout/stderr closed"
 		err = fmt.Errorf("%s:\n\t%s", ErrUnexpectedExit, a.lastLogLine)
+		crashed = a.crashed()
 	case <-a.t.Dying():
 		err = nil
 	}
 
 	a.Kill(reason)
+	a.stopSidecars()
 	a.Command.Wait()
+	a.exitCode = a.Command.ProcessState.ExitCode()
+	<-a.stopped
 	a.pool.remove(a)
 
+	if cgErr := a.cgroup.remove(); cgErr != nil {
+		a.eventAdd("cgroup_error", "error", cgErr.Error())
+	}
+
+	if crashed {
+		a.pool.recordCrash(a, a.failureDetails(err))
+	} else {
+		a.pool.forgetCrashes(a.Name)
+	}
+
 	if a.Scheme == "httpu" {
 		os.Remove(a.Address())
+	} else {
+		a.pool.releasePort(a.Port)
 	}
 
 	a.eventAdd("shutdown")
+	a.logFile.Close()
 
 	fmt.Printf("* App '%s' shutdown and cleaned up\n", a.Name)
 
 	return err
 }
 
+// idleMonitor stops the app once it has been idle for too long or has grown
+// past its max_rss.
 func (a *App) idleMonitor() error {
-	ticker := time.NewTicker(10 * time.Second)
+	idleTime := a.Config.IdleTime.Duration
+
+	interval := 10 * time.Second
+	if idleTime > 0 && idleTime < interval {
+		interval = idleTime
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if a.pool.maybeIdle(a) {
+			if a.checkMemory() {
+				return nil
+			}
+
+			if idleTime > 0 && a.pool.maybeIdle(a) {
 				a.Kill("app is idle")
 				return nil
 			}
@@ -181,14 +319,30 @@ func (a *App) restartMonitor() error {
 
 	restart := filepath.Join(tmpDir, "restart.txt")
 
-	f, err := os.Create(restart)
+	// Don't truncate an existing file, that would look like a restart to
+	// any other instance of the app that is watching it.
+	f, err := os.OpenFile(restart, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
 	f.Close()
 
-	return watch.Watch(restart, a.t.Dying(), func() {
-		a.Kill("restart.txt touched")
+	triggers := append([]string{"tmp/restart.txt"}, a.Config.RestartTriggers...)
+
+	return watch.WatchGlobs(a.dir, triggers, a.Config.RestartDebounce.Duration, a.t.Dying(), func(trigger string) {
+		reason := trigger + " changed"
+		if trigger == "tmp/restart.txt" {
+			reason = "restart.txt touched"
+		}
+
+		switch a.Config.RestartStrategy {
+		case RestartBlueGreen:
+			go a.pool.replaceApp(a, reason, trigger)
+		case RestartPhased, RestartHot:
+			go a.signalRestart(a.Config.RestartStrategy, reason, trigger)
+		default:
+			a.kill(reason, "trigger", trigger)
+		}
 	})
 }
 
@@ -226,7 +380,7 @@ func (a *App) Status() int {
 		case <-a.readyChan:
 			return Running
 		default:
-			return Dead
+			return Booting
 		}
 	}
 }
@@ -237,6 +391,9 @@ func (a *App) Log() string {
 	return buf.String()
 }
 
+// executionShell sets up the app's environment once the login shell has
+// run. The dotenv files are parsed by puma-dev and passed in as
+// PUMADEV_DOTENV_* exports, see dotenvEnv.
 const executionShell = `exec bash -c '
 cd %s
 
@@ -244,37 +401,61 @@ if test -e ~/.powconfig && [ "$PUMADEV_SOURCE_POWCONFIG" != "0" ]; then
 	source ~/.powconfig
 fi
 
-if test -e .env && [ "$PUMADEV_SOURCE_ENV" != "0" ]; then
-	source .env
+if [ -n "$PUMADEV_DOTENV_ENV" ] && [ "$PUMADEV_SOURCE_ENV" != "0" ]; then
+	eval "$PUMADEV_DOTENV_ENV"
 fi
 
 if test -e .powrc && [ "$PUMADEV_SOURCE_POWRC" != "0" ]; then
 	source .powrc
 fi
 
-if test -e .powenv && [ "$PUMADEV_SOURCE_POWENV" != "0" ]; then
-	source .powenv
+if [ -n "$PUMADEV_DOTENV_POWENV" ] && [ "$PUMADEV_SOURCE_POWENV" != "0" ]; then
+	eval "$PUMADEV_DOTENV_POWENV"
 fi
 
-if test -e .pumaenv && [ "$PUMADEV_SOURCE_PUMAENV" != "0" ]; then
-	source .pumaenv
+if [ -n "$PUMADEV_DOTENV_PUMAENV" ] && [ "$PUMADEV_SOURCE_PUMAENV" != "0" ]; then
+	eval "$PUMADEV_DOTENV_PUMAENV"
 fi
 
-if test -e Gemfile && bundle exec puma -V &>/dev/null; then
-	exec bundle exec puma -C $CONFIG --tag puma-dev:%s -w $WORKERS -t 0:$THREADS -b unix:%s
-fi
+unset PUMADEV_DOTENV_ENV PUMADEV_DOTENV_POWENV PUMADEV_DOTENV_PUMAENV
 
-exec puma -C $CONFIG --tag puma-dev:%s -w $WORKERS -t 0:$THREADS -b unix:%s'
+%s'
 `
 
+const pumaCommand = `if test -e Gemfile && bundle exec puma -V &>/dev/null; then
+	exec bundle exec puma -C $CONFIG --tag puma-dev:%s -w $WORKERS -t 0:$THREADS -b %s
+fi
+
+exec puma -C $CONFIG --tag puma-dev:%s -w $WORKERS -t 0:$THREADS -b %s`
+
+// The custom command is passed through the environment so it doesn't need
+// to be quoted for the wrapper script.
+const customCommand = `exec bash -c "$PUMADEV_COMMAND"`
+
 func (pool *AppPool) LaunchApp(name, dir string) (*App, error) {
-	tmpDir := filepath.Join(dir, "tmp")
-	err := os.MkdirAll(tmpDir, 0755)
+	return pool.launchApp(name, dir, "", "")
+}
+
+// launchApp boots an app, with the env of profile if it isn't empty.
+// instance tells apart several instances of the same app running at once,
+// such as during a blue/green restart.
+func (pool *AppPool) launchApp(name, dir, profile, instance string) (*App, error) {
+	cfg, err := pool.LoadConfig(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	socket := filepath.Join(tmpDir, fmt.Sprintf("puma-dev-%d.sock", os.Getpid()))
+	if profile != "" {
+		if err := cfg.ApplyProfile(profile); err != nil {
+			return nil, errors.Context(err, "launching "+name)
+		}
+	}
+
+	tmpDir := filepath.Join(dir, "tmp")
+	err = os.MkdirAll(tmpDir, 0755)
+	if err != nil {
+		return nil, err
+	}
 
 	shell := os.Getenv("SHELL")
 
@@ -283,76 +464,256 @@ func (pool *AppPool) LaunchApp(name, dir string) (*App, error) {
 		shell = "/bin/bash"
 	}
 
-	cmd := exec.Command(shell, "-l", "-i", "-c",
-		fmt.Sprintf(executionShell, dir, name, socket, name, socket))
+	webCommand, err := cfg.WebCommand(dir)
+	if err != nil {
+		return nil, err
+	}
 
-	cmd.Dir = dir
+	sidecars, err := cfg.SidecarEntries(dir)
+	if err != nil {
+		return nil, err
+	}
 
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env,
-		fmt.Sprintf("THREADS=%d", DefaultThreads),
-		"WORKERS=0",
-		"CONFIG=-",
+	env := append(os.Environ(), cfg.Environ()...)
+
+	dotenv := dotenvEnv(dir, env)
+
+	var (
+		socket, bind string
+		port         int
 	)
 
+	if cfg.Listen == ListenTCP {
+		port, err = pool.allocatePort()
+		if err != nil {
+			return nil, err
+		}
+
+		bind = fmt.Sprintf("tcp://127.0.0.1:%d", port)
+	} else {
+		// Variants share the app's tmp dir, so they need their own socket.
+		socketInstance := instance
+		if profile != "" {
+			socketInstance = VariantSeparator + profile + instance
+		}
+
+		socket, err = pool.socketPath(name, tmpDir, socketInstance)
+		if err != nil {
+			return nil, err
+		}
+
+		bind = "unix:" + socket
+	}
+
+	launch := fmt.Sprintf(pumaCommand, name, bind, name, bind)
+	script := launch
+	if webCommand != "" {
+		launch = webCommand
+		script = customCommand
+	}
+
+	var (
+		envKey     string
+		envCached  bool
+		envCapture string
+	)
+
+	if pool.CacheEnv {
+		envKey = envCacheKey(shell, dir, env)
+
+		var cached []string
+		cached, envCached = pool.cachedEnv(name, envKey)
+		if envCached {
+			env = cached
+		}
+	}
+
+	// Unless it came from the cache, capture the environment the app gets
+	// so it can be shown in the status API.
+	if !envCached {
+		envCapture, err = envCaptureFile()
+		if err != nil {
+			pool.releasePort(port)
+			return nil, err
+		}
+
+		script = envCaptureScript + script
+		env = append(env, dotenv...)
+	}
+
+	var cmd *exec.Cmd
+
+	if envCached {
+		// The cached environment already has everything the shell wrapper
+		// would have set up, so skip straight to the app.
+		cmd = exec.Command("bash", "-c", script)
+	} else {
+		cmd = exec.Command(shell, "-l", "-i", "-c",
+			fmt.Sprintf(executionShell, dir, script))
+	}
+
+	cmd.Dir = dir
+
+	// Run the app in its own session, and so its own process group, so
+	// stopping it reaches any workers or children it started too. A new
+	// process group alone would be a background group of puma-dev's
+	// terminal, and the interactive shell would be stopped by SIGTTIN.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	cmd.Env = append([]string{}, env...)
+
+	if envCapture != "" {
+		cmd.Env = append(cmd.Env, "PUMADEV_ENV_CAPTURE="+envCapture)
+	}
+
+	if port != 0 {
+		cmd.Env = append(cmd.Env, "PORT="+strconv.Itoa(port))
+	} else {
+		cmd.Env = append(cmd.Env, "SOCKET="+socket)
+	}
+
+	if webCommand != "" {
+		cmd.Env = append(cmd.Env, "PUMADEV_COMMAND="+webCommand)
+	}
+
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
+		pool.releasePort(port)
 		return nil, err
 	}
 
 	cmd.Stderr = cmd.Stdout
 
+	cg, err := pool.createCgroup(name+instance, cfg.Cgroup)
+	if err != nil {
+		pool.releasePort(port)
+		return nil, err
+	}
+
+	cg.wrap(cmd)
+
 	err = cmd.Start()
 	if err != nil {
+		if envCapture != "" {
+			os.Remove(envCapture)
+		}
+
+		cg.remove()
+		pool.releasePort(port)
 		return nil, errors.Context(err, "starting app")
 	}
 
-	fmt.Printf("! Booting app '%s' on socket %s\n", name, socket)
+	cgErr := cg.join(cmd.Process.Pid)
+
+	listen := []interface{}{"socket", socket}
+	if port != 0 {
+		listen = []interface{}{"port", port}
+		fmt.Printf("! Booting app '%s' on port %d\n", name, port)
+	} else {
+		fmt.Printf("! Booting app '%s' on socket %s\n", name, socket)
+	}
 
 	app := &App{
 		Name:      name,
 		Command:   cmd,
 		Events:    pool.Events,
+		Config:    cfg,
 		stdout:    stdout,
 		dir:       dir,
 		pool:      pool,
 		readyChan: make(chan struct{}),
+		stopped:   make(chan struct{}),
 		lastUse:   time.Now(),
+		started:   time.Now(),
+		cgroup:    cg,
+		logFile:   pool.logFile(name),
+		profile:   profile,
+
+		launchCommand: launch,
+	}
+
+	if webCommand != "" {
+		listen = append(listen, "command", webCommand)
+	}
+
+	if envCached {
+		app.env = env
+		listen = append(listen, "env", "cached")
 	}
 
-	app.eventAdd("booting_app", "socket", socket)
+	app.eventAdd("booting_app", listen...)
+
+	if envCapture != "" {
+		go pool.saveEnv(app, envKey, envCapture)
+	}
+
+	for _, path := range cfg.Files {
+		fmt.Printf("* Using config for '%s' from %s\n", name, path)
+	}
+
+	if cgErr != nil {
+		app.eventAdd("cgroup_error", "error", cgErr.Error())
+		fmt.Printf("! Unable to move '%s' into its cgroup: %s\n", name, cgErr)
+	} else if cg != nil {
+		app.eventAdd("cgroup_joined", "cgroup", cg.usage().Path)
+	}
 
 	stat, err := os.Stat(filepath.Join(dir, "public"))
 	if err == nil {
 		app.Public = stat.IsDir()
 	}
 
-	app.SetAddress("httpu", socket, 0)
+	if port != 0 {
+		app.SetAddress("http", "127.0.0.1", port)
+	} else {
+		app.SetAddress("httpu", socket, 0)
+	}
+
+	if instance == "" {
+		app.startSidecars(shell, envCached, sidecars, env)
+	} else {
+		app.pendingSidecars = func() {
+			app.startSidecars(shell, envCached, sidecars, env)
+		}
+	}
 
 	app.t.Go(app.watch)
 	app.t.Go(app.idleMonitor)
 	app.t.Go(app.restartMonitor)
 
 	app.t.Go(func() error {
-		// This is a poor substitute for getting an actual readiness signal
-		// from puma but it's good enough.
+		// Without a readiness path this is a poor substitute for getting an
+		// actual readiness signal from puma but it's good enough.
 
 		app.eventAdd("waiting_on_app")
 
-		ticker := time.NewTicker(250 * time.Millisecond)
+		ticker := time.NewTicker(cfg.Readiness.Interval.Duration)
 		defer ticker.Stop()
+
+		var timeout <-chan time.Time
+		if cfg.BootTimeout.Duration > 0 {
+			timer := time.NewTimer(cfg.BootTimeout.Duration)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
 		for {
 			select {
 			case <-app.t.Dying():
 				app.eventAdd("dying_on_start")
 				fmt.Printf("! Detecting app '%s' dying on start\n", name)
 				return fmt.Errorf("app died before booting")
+			case <-timeout:
+				app.eventAdd("boot_timeout", "timeout", cfg.BootTimeout.String())
+				fmt.Printf("! App '%s' did not boot within %s\n", name, cfg.BootTimeout)
+				return ErrBootTimeout
 			case <-ticker.C:
-				c, err := net.Dial("unix", socket)
+				err := app.probe(cfg.Readiness)
 				if err == nil {
-					c.Close()
-					app.eventAdd("app_ready")
-					fmt.Printf("! App '%s' booted\n", name)
+					bootTime := time.Since(app.started)
+					app.eventAdd("app_ready", "boot_time", bootTime.String())
+					fmt.Printf("! App '%s' booted in %s\n", name, bootTime)
+					app.readyAt = time.Now()
 					close(app.readyChan)
 					return nil
 				}
@@ -377,44 +738,43 @@ func (pool *AppPool) readProxy(name, path string) (*App, error) {
 		lastUse:   time.Now(),
 	}
 
-	data = bytes.TrimSpace(data)
+	cfg, err := ParseProxyFile(data)
+	if err != nil {
+		return nil, errors.Context(err, "reading "+path)
+	}
 
-	port, err := strconv.Atoi(string(data))
-	if err == nil {
-		app.SetAddress("http", "127.0.0.1", port)
-	} else {
-		u, err := url.Parse(string(data))
-		if err != nil {
-			return nil, err
-		}
+	scheme, host, port, err := cfg.target()
+	if err != nil {
+		return nil, errors.Context(err, "reading "+path)
+	}
 
-		var (
-			sport, host string
-			port        int
-		)
+	app.SetAddress(scheme, host, port)
 
-		host, sport, err = net.SplitHostPort(u.Host)
-		if err == nil {
-			port, err = strconv.Atoi(sport)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			host = u.Host
+	// Plain ports and URLs use the server's shared proxies.
+	if cfg.structured {
+		app.proxy, err = cfg.balancer(app)
+		if err != nil {
+			return nil, errors.Context(err, "reading "+path)
 		}
+	}
 
-		app.SetAddress(u.Scheme, host, port)
+	destination := fmt.Sprintf("%s://%s", app.Scheme, app.Address())
+	if len(cfg.Upstreams) > 1 {
+		destination = fmt.Sprintf("%s and %d more", cfg.Upstreams[0], len(cfg.Upstreams)-1)
 	}
 
-	app.eventAdd("proxy_created",
-		"destination", fmt.Sprintf("%s://%s", app.Scheme, app.Address()))
+	app.eventAdd("proxy_created", "destination", destination)
 
-	fmt.Printf("* Generated proxy connection for '%s' to %s://%s\n",
-		name, app.Scheme, app.Address())
+	fmt.Printf("* Generated proxy connection for '%s' to %s\n", name, destination)
 
 	// to satisfy the tomb
 	app.t.Go(func() error {
 		<-app.t.Dying()
+
+		if app.proxy != nil {
+			app.proxy.close()
+		}
+
 		return nil
 	})
 
@@ -424,23 +784,66 @@ func (pool *AppPool) readProxy(name, path string) (*App, error) {
 }
 
 type AppPool struct {
-	Dir      string
-	IdleTime time.Duration
-	Debug    bool
-	Events   *Events
+	Dir          string
+	IdleTime     time.Duration
+	BootTimeout  time.Duration
+	StopTimeout  time.Duration
+	Prewarm      []string
+	MaxRunning   int
+	CgroupRoot   string
+	SocketDir    string
+	MinPort      int
+	MaxPort      int
+	LogDir       string
+	LogMaxSize   ByteSize
+	LogKeep      int
+	CacheEnv     bool
+	GitWorktrees bool
+	Debug        bool
+	Events       *Events
 
 	AppClosed func(*App)
 
-	lock sync.Mutex
-	apps map[string]*App
+	lock    sync.Mutex
+	apps    map[string]*App
+	crashes map[string]*crashLoop
+
+	// keepWarm holds the names of the apps Warm booted, so they are still
+	// kept warm after being relaunched.
+	keepWarm map[string]bool
+
+	instances uint64
+
+	portLock sync.Mutex
+	ports    map[int]bool
+	nextPort int
+
+	logLock sync.Mutex
+	logs    map[string]*logFile
+
+	envLock  sync.Mutex
+	envCache map[string]envCacheEntry
+
+	// worktreeLock keeps git from creating the same worktree twice.
+	worktreeLock sync.Mutex
 }
 
 func (a *AppPool) maybeIdle(app *App) bool {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
+	if a.keptWarm(app) {
+		return false
+	}
+
+	// An instance being drained after a blue/green restart is no longer in
+	// the pool and is stopped by the restart.
+	if a.apps[app.Name] != app {
+		return false
+	}
+
 	diff := time.Since(app.lastUse)
-	if diff > a.IdleTime {
+	if diff > app.Config.IdleTime.Duration {
 		app.eventAdd("idle_app", "last_used", diff.String())
 		delete(a.apps, app.Name)
 		return true
@@ -465,16 +868,61 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 		return app, nil
 	}
 
-	path := filepath.Join(a.Dir, name)
+	path, stat, canonicalName, aliasName, err := a.resolveApp(name)
+	if err != nil {
+		return nil, err
+	}
+
+	app, ok = a.apps[canonicalName]
+
+	if !ok {
+		err = a.checkCrashLoop(canonicalName, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if stat.IsDir() {
+			a.makeRoom()
+			app, err = a.LaunchApp(canonicalName, path)
+		} else {
+			app, err = a.readProxy(canonicalName, path)
+		}
+	}
+
+	if err != nil {
+		a.Events.Add("error_starting_app", "app", canonicalName, "error", err.Error())
+		return nil, err
+	}
+
+	a.apps[canonicalName] = app
+
+	if aliasName != "" {
+		a.apps[aliasName] = app
+	}
+
+	return app, nil
+}
+
+// resolveApp finds the directory or proxy file in the pool directory for
+// name, and the name the app runs under. aliasName is set when name is a
+// link whose target is named differently. It must be called with the pool
+// locked.
+func (a *AppPool) resolveApp(name string) (path string, stat os.FileInfo, canonicalName, aliasName string, err error) {
+	path = filepath.Join(a.Dir, name)
+
+	// The log dir defaults to being inside the app dir.
+	if a.LogDir != "" && path == filepath.Clean(a.LogDir) {
+		return "", nil, "", "", ErrUnknownApp
+	}
 
 	a.Events.Add("app_lookup", "path", path)
 
-	stat, err := os.Stat(path)
+	stat, err = os.Stat(path)
 	destPath, _ := os.Readlink(path)
 
 	if err != nil {
 		if !os.IsNotExist(err) {
-			return nil, err
+			return "", nil, "", "", err
 		}
 
 		// Check there might be a link there but it's not valid
@@ -486,8 +934,8 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 
 		// If possible, also try expanding - to / to allow for apps in subdirs
 		possible := strings.Replace(name, "-", "/", -1)
-		if possible == name {
-			return nil, ErrUnknownApp
+		if possible == name || a.isVariant(name) {
+			return "", nil, "", "", ErrUnknownApp
 		}
 
 		path = filepath.Join(a.Dir, possible)
@@ -499,7 +947,7 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 
 		if err != nil {
 			if !os.IsNotExist(err) {
-				return nil, err
+				return "", nil, "", "", err
 			}
 
 			// Check there might be a link there but it's not valid
@@ -509,12 +957,11 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 				a.Events.Add("bad_symlink", "path", path, "dest", destPath)
 			}
 
-			return nil, ErrUnknownApp
+			return "", nil, "", "", ErrUnknownApp
 		}
 	}
 
-	canonicalName := name
-	aliasName := ""
+	canonicalName = name
 
 	// Handle multiple symlinks to the same app
 	destStat, err := os.Stat(destPath)
@@ -529,28 +976,7 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 		}
 	}
 
-	app, ok = a.apps[canonicalName]
-
-	if !ok {
-		if stat.IsDir() {
-			app, err = a.LaunchApp(canonicalName, path)
-		} else {
-			app, err = a.readProxy(canonicalName, path)
-		}
-	}
-
-	if err != nil {
-		a.Events.Add("error_starting_app", "app", canonicalName, "error", err.Error())
-		return nil, err
-	}
-
-	a.apps[canonicalName] = app
-
-	if aliasName != "" {
-		a.apps[aliasName] = app
-	}
-
-	return app, nil
+	return path, stat, canonicalName, aliasName, nil
 }
 
 func pruneSub(name string) string {
@@ -566,19 +992,46 @@ func (a *AppPool) FindAppByDomainName(name string) (*App, error) {
 	var (
 		app *App
 		err error
+
+		// notBranch is a subdomain that turned out not to be a branch.
+		notBranch string
 	)
 
 	for name != "" {
 		app, err = a.lookupApp(name)
-		if err != nil {
+		if err == ErrUnknownApp {
+			app, err = a.lookupVariant(name)
+		}
+
+		if err == ErrUnknownApp {
+			fullName := name
+			label := strings.SplitN(name, ".", 2)[0]
+			name = pruneSub(name)
+			notBranch = ""
+
+			// With git worktrees, the subdomain of an app may be one of
+			// its branches.
+			if !a.GitWorktrees || name == "" {
+				continue
+			}
+
+			app, err = a.lookupWorktree(label, name)
 			if err == ErrUnknownApp {
-				name = pruneSub(name)
+				notBranch = fullName
 				continue
 			}
+		}
 
+		if err != nil {
 			return nil, err
 		}
 
+		// Remember the app the subdomain falls back to, so git isn't run
+		// for it again until that app stops.
+		if notBranch != "" {
+			a.addAlias(notBranch, app)
+		}
+
 		break
 	}
 
@@ -592,6 +1045,19 @@ func (a *AppPool) FindAppByDomainName(name string) (*App, error) {
 	return app, nil
 }
 
+// addAlias makes name another name of app, until app is removed.
+func (a *AppPool) addAlias(name string, app *App) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, candidate := range a.apps {
+		if candidate == app {
+			a.apps[name] = app
+			return
+		}
+	}
+}
+
 func (a *AppPool) remove(app *App) {
 	a.lock.Lock()
 	defer a.lock.Unlock()
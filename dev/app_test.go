@@ -0,0 +1,75 @@
+package dev
+
+import (
+	"bytes"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_Kill(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	assert.NoError(t, err)
+
+	writeAppOrFail(t, filepath.Join(poolDir, "graceful"), "")
+
+	// Ignored signals stay ignored across exec, so this reaches python too.
+	writeAppOrFail(t, filepath.Join(poolDir, "stubborn"), "")
+	writeFileOrFail(t, filepath.Join(poolDir, "stubborn", AppConfigFile),
+		"command = \"trap '' TERM; exec python3 server.py\"\n")
+
+	pool := &AppPool{
+		Dir:         poolDir,
+		IdleTime:    time.Hour,
+		BootTimeout: 20 * time.Second,
+		StopTimeout: time.Second,
+		Events:      &Events{},
+	}
+	defer pool.Purge()
+
+	stop := func(name string) *App {
+		app, err := pool.lookupApp(name)
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+
+		if err := app.WaitTilReady(); err != nil {
+			assert.FailNow(t, err.Error(), app.Log())
+		}
+
+		assert.NoError(t, app.Kill("test"))
+
+		select {
+		case <-app.t.Dead():
+		case <-time.After(10 * time.Second):
+			assert.FailNow(t, name+" was not stopped")
+		}
+
+		assert.Equal(t, syscall.ESRCH, syscall.Kill(-app.Command.Process.Pid, 0), name+" process group is gone")
+
+		return app
+	}
+
+	start := time.Now()
+	stop("graceful")
+	assert.True(t, time.Since(start) < 5*time.Second, "graceful app waited for the stop timeout")
+
+	start = time.Now()
+	stop("stubborn")
+	assert.True(t, time.Since(start) >= time.Second, "stubborn app was stopped before the stop timeout")
+
+	var events bytes.Buffer
+	pool.Events.WriteTo(&events)
+
+	assert.Contains(t, events.String(), `"event":"app_stopped","app":"graceful"`)
+	assert.NotContains(t, events.String(), `"event":"stop_escalated","app":"graceful"`)
+
+	assert.Contains(t, events.String(), `"event":"stop_escalated","app":"stubborn"`)
+	assert.NotContains(t, events.String(), `"event":"app_stopped","app":"stubborn"`)
+}
@@ -0,0 +1,376 @@
+package dev
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Strategies for spreading requests across a proxy's upstreams.
+const (
+	BalanceRoundRobin = "round-robin"
+	BalanceLeastConn  = "least-connections"
+)
+
+// DefaultMaxFails and DefaultFailTimeout take an upstream out of rotation
+// for 10 seconds as soon as a request to it fails.
+const (
+	DefaultMaxFails    = 1
+	DefaultFailTimeout = 10 * time.Second
+)
+
+// balancer serves a structured proxy, spreading requests across its
+// upstreams and leaving out the ones that recently failed.
+type balancer struct {
+	app *App
+	cfg *ProxyConfig
+
+	lock      sync.Mutex
+	upstreams []*upstream
+	next      int
+}
+
+// upstream is one address of a proxy. Everything but url, proxy and
+// transport is protected by the balancer's lock.
+type upstream struct {
+	url       string
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+
+	active    int
+	requests  uint64
+	fails     int
+	failedAt  time.Time
+	downUntil time.Time
+	down      bool
+	lastError string
+}
+
+// UpstreamStatus is how an upstream is shown in /status.
+type UpstreamStatus struct {
+	URL       string     `json:"url"`
+	Healthy   bool       `json:"healthy"`
+	Active    int        `json:"active"`
+	Requests  uint64     `json:"requests"`
+	Fails     int        `json:"fails"`
+	DownUntil *time.Time `json:"down_until,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// attempt tracks the upstreams a request was sent to, so it can be retried
+// on another one.
+type attempt struct {
+	req   *http.Request
+	tried map[*upstream]bool
+}
+
+type attemptKey struct{}
+
+// balancer returns the handler for a structured proxy to app. Each
+// upstream has its own transport, so the timeouts and TLS settings only
+// apply to this app.
+func (cfg *ProxyConfig) balancer(app *App) (*balancer, error) {
+	b := &balancer{app: app, cfg: cfg}
+
+	for _, rawURL := range cfg.Upstreams {
+		u, err := b.newUpstream(rawURL)
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+
+		b.upstreams = append(b.upstreams, u)
+	}
+
+	return b, nil
+}
+
+func (b *balancer) newUpstream(rawURL string) (*upstream, error) {
+	cfg := b.cfg
+
+	scheme, host, port, err := proxyTarget(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	dial := dialerTimeout
+	if cfg.DialTimeout.Duration > 0 {
+		dial = cfg.DialTimeout.Duration
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   dial,
+		KeepAlive: keepAlive,
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
+		ResponseHeaderTimeout: cfg.ResponseTimeout.Duration,
+	}
+
+	if cfg.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	// Unix sockets are dialed directly, and there's no host to rewrite to
+	// but localhost.
+	if scheme == "httpu" {
+		socketPath := host
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+
+		scheme, host = "http", "localhost"
+	}
+
+	u := &upstream{url: rawURL, transport: transport}
+
+	director := func(req *http.Request) {
+		req.URL.Scheme, req.URL.Host = scheme, host
+
+		switch cfg.HostHeader {
+		case HostPreserve:
+		case HostRewrite:
+			req.Host = host
+		default:
+			req.Host = cfg.HostHeader
+		}
+
+		setHeaders(req.Header, cfg.RequestHeaders)
+	}
+
+	modifyResponse := func(res *http.Response) error {
+		b.succeeded(u)
+		setHeaders(res.Header, cfg.ResponseHeaders)
+		return nil
+	}
+
+	errorHandler := func(w http.ResponseWriter, req *http.Request, err error) {
+		// The client going away isn't the upstream's fault.
+		if req.Context().Err() == nil {
+			b.failed(u, err)
+		}
+
+		// Nothing was sent if the connection failed, so requests without a
+		// body can safely go to another upstream.
+		if at, ok := req.Context().Value(attemptKey{}).(*attempt); ok && isDialError(err) {
+			if at.req.Body == nil || at.req.Body == http.NoBody {
+				if b.serve(w, at) {
+					return
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	u.proxy = &httputil.ReverseProxy{
+		Director:       director,
+		Transport:      transport,
+		FlushInterval:  proxyFlushInternal,
+		ModifyResponse: modifyResponse,
+		ErrorHandler:   errorHandler,
+	}
+
+	return u, nil
+}
+
+func (b *balancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	at := &attempt{tried: map[*upstream]bool{}}
+	at.req = req.WithContext(context.WithValue(req.Context(), attemptKey{}, at))
+
+	b.serve(w, at)
+}
+
+// serve sends the request to the next upstream it hasn't been sent to. It
+// returns false if it has been sent to all of them.
+func (b *balancer) serve(w http.ResponseWriter, at *attempt) bool {
+	u := b.pick(at.tried)
+	if u == nil {
+		return false
+	}
+
+	at.tried[u] = true
+
+	defer func() {
+		b.lock.Lock()
+		u.active--
+		b.lock.Unlock()
+	}()
+
+	u.proxy.ServeHTTP(w, at.req)
+
+	return true
+}
+
+// pick chooses the upstream for a request and counts the request against
+// it. Upstreams that are down are only picked when all the others have
+// been tried.
+func (b *balancer) pick(tried map[*upstream]bool) *upstream {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+
+	var healthy, down []int
+
+	for i := range b.upstreams {
+		idx := (b.next + i) % len(b.upstreams)
+		u := b.upstreams[idx]
+
+		switch {
+		case tried[u]:
+		case u.downUntil.After(now):
+			down = append(down, idx)
+		default:
+			healthy = append(healthy, idx)
+		}
+	}
+
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = down
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	chosen := candidates[0]
+
+	if b.cfg.Balance == BalanceLeastConn {
+		for _, idx := range candidates[1:] {
+			if b.upstreams[idx].active < b.upstreams[chosen].active {
+				chosen = idx
+			}
+		}
+	}
+
+	b.next = (chosen + 1) % len(b.upstreams)
+
+	u := b.upstreams[chosen]
+	u.active++
+	u.requests++
+
+	return u
+}
+
+// failed records a failed request to u, taking it out of rotation if it
+// failed too often.
+func (b *balancer) failed(u *upstream, err error) {
+	b.lock.Lock()
+
+	now := time.Now()
+
+	u.lastError = err.Error()
+
+	if b.cfg.MaxFails <= 0 {
+		b.lock.Unlock()
+		return
+	}
+
+	if u.fails == 0 || now.Sub(u.failedAt) > b.cfg.FailTimeout.Duration {
+		u.fails = 0
+		u.failedAt = now
+	}
+
+	u.fails++
+
+	wentDown := false
+	if u.fails >= b.cfg.MaxFails && !u.downUntil.After(now) {
+		u.downUntil = now.Add(b.cfg.FailTimeout.Duration)
+		u.down = true
+		wentDown = true
+	}
+
+	b.lock.Unlock()
+
+	if wentDown {
+		b.app.eventAdd("upstream_down", "upstream", u.url, "error", err.Error(),
+			"retry_in", b.cfg.FailTimeout.String())
+	}
+}
+
+// succeeded records that u responded, putting it back in rotation if it
+// was down.
+func (b *balancer) succeeded(u *upstream) {
+	b.lock.Lock()
+
+	u.fails = 0
+	cameUp := u.down
+	u.down = false
+	u.downUntil = time.Time{}
+
+	b.lock.Unlock()
+
+	if cameUp {
+		b.app.eventAdd("upstream_up", "upstream", u.url)
+	}
+}
+
+// statuses returns the state of each upstream, or nil for apps that
+// aren't structured proxies.
+func (b *balancer) statuses() []UpstreamStatus {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+
+	var statuses []UpstreamStatus
+
+	for _, u := range b.upstreams {
+		status := UpstreamStatus{
+			URL:       u.url,
+			Healthy:   !u.downUntil.After(now),
+			Active:    u.active,
+			Requests:  u.requests,
+			Fails:     u.fails,
+			LastError: u.lastError,
+		}
+
+		if !status.Healthy {
+			downUntil := u.downUntil
+			status.DownUntil = &downUntil
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func (b *balancer) close() {
+	for _, u := range b.upstreams {
+		u.transport.CloseIdleConnections()
+	}
+}
+
+func isDialError(err error) bool {
+	oe, ok := err.(*net.OpError)
+	return ok && oe.Op == "dial"
+}
+
+func setHeaders(header http.Header, values map[string]string) {
+	for name, value := range values {
+		if value == "" {
+			header.Del(name)
+		} else {
+			header.Set(name, value)
+		}
+	}
+}
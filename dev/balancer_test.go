@@ -0,0 +1,222 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func namedUpstream(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+}
+
+// deadURL returns the URL of a port nothing listens on.
+func deadURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	l.Close()
+
+	return "http://" + l.Addr().String()
+}
+
+func getBody(t *testing.T, url string) (int, string) {
+	res, err := http.Get(url)
+	if !assert.NoError(t, err) {
+		return 0, ""
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	return res.StatusCode, string(body)
+}
+
+func TestParseProxyFile_upstreams(t *testing.T) {
+	cfg, err := ParseProxyFile([]byte(`
+upstreams = ["3000", "localhost:3001", "/tmp/app.sock"]
+balance = "least-connections"
+max_fails = 3
+fail_timeout = "30s"
+`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://127.0.0.1:3000", "http://localhost:3001", "unix:///tmp/app.sock"}, cfg.Upstreams)
+	assert.Equal(t, BalanceLeastConn, cfg.Balance)
+	assert.Equal(t, 3, cfg.MaxFails)
+	assert.Equal(t, 30*time.Second, cfg.FailTimeout.Duration)
+
+	cfg, err = ParseProxyFile([]byte(`url = "http://localhost:3000"`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost:3000"}, cfg.Upstreams)
+	assert.Equal(t, BalanceRoundRobin, cfg.Balance)
+	assert.Equal(t, DefaultMaxFails, cfg.MaxFails)
+	assert.Equal(t, DefaultFailTimeout, cfg.FailTimeout.Duration)
+
+	_, err = ParseProxyFile([]byte(`{"url": "http://localhost:3000", "upstreams": ["3001"]}`))
+	assert.EqualError(t, err, "set either url or upstreams, not both")
+
+	_, err = ParseProxyFile([]byte(`{"upstreams": ["3001"], "balance": "random"}`))
+	assert.EqualError(t, err, "unknown balance 'random', expected round-robin or least-connections")
+}
+
+func TestBalancer_roundRobin(t *testing.T) {
+	a := namedUpstream("a")
+	defer a.Close()
+
+	b := namedUpstream("b")
+	defer b.Close()
+
+	server, stop := proxyTo(t, &ProxyConfig{Upstreams: []string{a.URL, b.URL}, HostHeader: HostPreserve})
+	defer stop()
+
+	var bodies []string
+
+	for i := 0; i < 4; i++ {
+		_, body := getBody(t, server.URL)
+		bodies = append(bodies, body)
+	}
+
+	assert.Equal(t, []string{"a", "b", "a", "b"}, bodies)
+}
+
+func TestBalancer_leastConnections(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+		fmt.Fprint(w, "slow")
+	}))
+	defer slow.Close()
+
+	fast := namedUpstream("fast")
+	defer fast.Close()
+
+	server, stop := proxyTo(t, &ProxyConfig{
+		Upstreams:  []string{slow.URL, fast.URL},
+		Balance:    BalanceLeastConn,
+		HostHeader: HostPreserve,
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		_, body := getBody(t, server.URL)
+		assert.Equal(t, "slow", body)
+	}()
+
+	<-started
+
+	for i := 0; i < 3; i++ {
+		_, body := getBody(t, server.URL)
+		assert.Equal(t, "fast", body)
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestBalancer_failover(t *testing.T) {
+	live := namedUpstream("live")
+	defer live.Close()
+
+	dead := deadURL(t)
+
+	server, stop := proxyTo(t, &ProxyConfig{
+		Upstreams:   []string{dead, live.URL},
+		HostHeader:  HostPreserve,
+		MaxFails:    1,
+		FailTimeout: Duration{time.Minute},
+	})
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		code, body := getBody(t, server.URL)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "live", body)
+	}
+}
+
+func TestBalancer_statuses(t *testing.T) {
+	live := namedUpstream("live")
+	defer live.Close()
+
+	dead := deadURL(t)
+
+	proxyApp := &App{Name: "proxy", Events: &Events{}}
+
+	cfg := &ProxyConfig{
+		Upstreams:   []string{dead, live.URL},
+		Balance:     BalanceRoundRobin,
+		HostHeader:  HostPreserve,
+		MaxFails:    1,
+		FailTimeout: Duration{time.Minute},
+	}
+
+	b, err := cfg.balancer(proxyApp)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer b.close()
+
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	getBody(t, server.URL)
+
+	statuses := b.statuses()
+	if !assert.Len(t, statuses, 2) {
+		return
+	}
+
+	assert.Equal(t, dead, statuses[0].URL)
+	assert.False(t, statuses[0].Healthy)
+	assert.Equal(t, 1, statuses[0].Fails)
+	assert.NotNil(t, statuses[0].DownUntil)
+	assert.NotEmpty(t, statuses[0].LastError)
+
+	assert.Equal(t, live.URL, statuses[1].URL)
+	assert.True(t, statuses[1].Healthy)
+	assert.Equal(t, uint64(1), statuses[1].Requests)
+	assert.Equal(t, 0, statuses[1].Active)
+
+	assert.Nil(t, (&App{}).proxy.statuses())
+
+	var events bytes.Buffer
+	proxyApp.Events.WriteTo(&events)
+	assert.Contains(t, events.String(), `"event":"upstream_down"`)
+}
+
+func TestBalancer_allDown(t *testing.T) {
+	server, stop := proxyTo(t, &ProxyConfig{
+		Upstreams:   []string{deadURL(t), deadURL(t)},
+		HostHeader:  HostPreserve,
+		MaxFails:    1,
+		FailTimeout: Duration{time.Minute},
+	})
+	defer stop()
+
+	code, _ := getBody(t, server.URL)
+	assert.Equal(t, http.StatusBadGateway, code)
+
+	// Upstreams that are down are still tried when there's nothing else.
+	code, _ = getBody(t, server.URL)
+	assert.Equal(t, http.StatusBadGateway, code)
+}
@@ -0,0 +1,43 @@
+package dev
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CgroupConfig holds the cgroup v2 limits for an app, in the same format as
+// the memory.max, cpu.max and pids.max files. cpu_max also accepts a
+// percentage of one CPU, such as "150%". Unset limits are left at "max".
+type CgroupConfig struct {
+	MemoryMax string `toml:"memory_max" json:"memory_max,omitempty"`
+	CPUMax    string `toml:"cpu_max" json:"cpu_max,omitempty"`
+	PidsMax   string `toml:"pids_max" json:"pids_max,omitempty"`
+}
+
+// cgroupCPUPeriod is the default cpu.max period, in microseconds.
+const cgroupCPUPeriod = 100000
+
+func (cfg CgroupConfig) cpuMax() string {
+	if !strings.HasSuffix(cfg.CPUMax, "%") {
+		return cfg.CPUMax
+	}
+
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(cfg.CPUMax, "%"), 64)
+	if err != nil || pct <= 0 {
+		// Let the kernel reject it with a useful error.
+		return cfg.CPUMax
+	}
+
+	return strconv.Itoa(int(pct*cgroupCPUPeriod/100)) + " " + strconv.Itoa(cgroupCPUPeriod)
+}
+
+// CgroupUsage is the current state of an app's cgroup, as shown in /status.
+type CgroupUsage struct {
+	Path          string   `json:"path"`
+	MemoryCurrent uint64   `json:"memory_current"`
+	MemoryMax     string   `json:"memory_max"`
+	CPUUsage      Duration `json:"cpu_usage"`
+	CPUMax        string   `json:"cpu_max"`
+	PidsCurrent   uint64   `json:"pids_current"`
+	PidsMax       string   `json:"pids_max"`
+}
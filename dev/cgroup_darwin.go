@@ -0,0 +1,18 @@
+package dev
+
+import "os/exec"
+
+// cgroups only exist on Linux, so apps are never put in one on macOS.
+type cgroup struct{}
+
+func (pool *AppPool) createCgroup(name string, cfg CgroupConfig) (*cgroup, error) {
+	return nil, nil
+}
+
+func (cg *cgroup) wrap(cmd *exec.Cmd) {}
+
+func (cg *cgroup) join(pid int) error { return nil }
+
+func (cg *cgroup) remove() error { return nil }
+
+func (cg *cgroup) usage() *CgroupUsage { return nil }
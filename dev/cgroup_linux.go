@@ -0,0 +1,240 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vektra/errors"
+)
+
+var cgroupControllers = []string{"cpu", "memory", "pids"}
+
+// DelegatedCgroup finds the cgroup v2 subtree systemd delegates to the
+// current user, which is where an unprivileged process may create groups.
+// It returns the puma-dev group inside it.
+func DelegatedCgroup() (string, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	service := fmt.Sprintf("user@%d.service", os.Getuid())
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+
+		path := strings.TrimPrefix(line, "0::")
+
+		idx := strings.Index(path, "/"+service)
+		if idx == -1 {
+			break
+		}
+
+		return filepath.Join(mount, path[:idx+len(service)+1], "puma-dev"), nil
+	}
+
+	return "", fmt.Errorf("not running under %s, pass -cgroup-root", service)
+}
+
+func cgroup2Mount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The filesystem type follows the " - " separator.
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		fields := strings.Fields(parts[0])
+		if strings.HasPrefix(parts[1], "cgroup2 ") && len(fields) > 4 {
+			return fields[4], nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no cgroup v2 filesystem is mounted")
+}
+
+// SetupCgroups creates root and enables the controllers puma-dev limits for
+// the groups created under it. Apps are only put in cgroups once this has
+// succeeded.
+func (pool *AppPool) SetupCgroups(root string) error {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return errors.Context(err, "creating cgroup "+root)
+	}
+
+	// Controllers have to be enabled in the parent before they can be
+	// enabled for our children. Not all of them may be delegated, so each
+	// one is tried on its own.
+	for _, dir := range []string{filepath.Dir(root), root} {
+		for _, ctrl := range cgroupControllers {
+			writeCgroupFile(dir, "cgroup.subtree_control", "+"+ctrl)
+		}
+	}
+
+	pool.CgroupRoot = root
+
+	return nil
+}
+
+type cgroup struct {
+	path string
+}
+
+// createCgroup makes the group an app is moved into and applies its limits.
+// It returns nil if cgroups aren't enabled.
+func (pool *AppPool) createCgroup(name string, cfg CgroupConfig) (*cgroup, error) {
+	if pool.CgroupRoot == "" {
+		return nil, nil
+	}
+
+	cg := &cgroup{path: filepath.Join(pool.CgroupRoot, name)}
+
+	// A group left over from an earlier run can be reused if it's empty.
+	err := os.Mkdir(cg.path, 0755)
+	if err != nil && !os.IsExist(err) {
+		return nil, errors.Context(err, "creating cgroup "+cg.path)
+	}
+
+	limits := []struct{ file, value string }{
+		{"memory.max", cfg.MemoryMax},
+		{"cpu.max", cfg.cpuMax()},
+		{"pids.max", cfg.PidsMax},
+	}
+
+	for _, limit := range limits {
+		value := limit.value
+		if value == "" {
+			value = "max"
+		}
+
+		err = writeCgroupFile(cg.path, limit.file, value)
+		if err != nil && limit.value != "" {
+			cg.remove()
+			return nil, errors.Context(err, "setting "+limit.file)
+		}
+	}
+
+	return cg, nil
+}
+
+// cgroupJoinScript moves itself into the cgroup.procs file given as $1 and
+// then execs the rest of its arguments, so the whole command runs in the
+// group from its first instruction.
+const cgroupJoinScript = `{ echo $$ > "$1"; } 2>/dev/null; shift; exec "$@"`
+
+// wrap makes cmd join the group before it execs, so nothing it forks early,
+// such as the login shell's profile, escapes the group. Call join after
+// starting it too, to find out if joining failed.
+func (cg *cgroup) wrap(cmd *exec.Cmd) {
+	if cg == nil {
+		return
+	}
+
+	args := []string{"sh", "-c", cgroupJoinScript, "sh", filepath.Join(cg.path, "cgroup.procs"), cmd.Path}
+
+	cmd.Path = "/bin/sh"
+	cmd.Args = append(args, cmd.Args[1:]...)
+}
+
+// join moves the process into the group. Children it starts afterwards are
+// created inside the group too.
+func (cg *cgroup) join(pid int) error {
+	if cg == nil {
+		return nil
+	}
+
+	return writeCgroupFile(cg.path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// remove deletes the group, killing anything that is still in it.
+func (cg *cgroup) remove() error {
+	if cg == nil {
+		return nil
+	}
+
+	err := syscall.Rmdir(cg.path)
+	if err != syscall.EBUSY {
+		return err
+	}
+
+	// cgroup.kill needs Linux 5.14, older kernels just leave the group
+	// behind until it is empty.
+	writeCgroupFile(cg.path, "cgroup.kill", "1")
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(100 * time.Millisecond)
+
+		err = syscall.Rmdir(cg.path)
+		if err != syscall.EBUSY {
+			return err
+		}
+	}
+
+	return err
+}
+
+// usage reads the group's current usage and limits.
+func (cg *cgroup) usage() *CgroupUsage {
+	if cg == nil {
+		return nil
+	}
+
+	usage := &CgroupUsage{
+		Path:      cg.path,
+		MemoryMax: readCgroupFile(cg.path, "memory.max"),
+		CPUMax:    readCgroupFile(cg.path, "cpu.max"),
+		PidsMax:   readCgroupFile(cg.path, "pids.max"),
+	}
+
+	usage.MemoryCurrent, _ = strconv.ParseUint(readCgroupFile(cg.path, "memory.current"), 10, 64)
+	usage.PidsCurrent, _ = strconv.ParseUint(readCgroupFile(cg.path, "pids.current"), 10, 64)
+
+	for _, line := range strings.Split(readCgroupFile(cg.path, "cpu.stat"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, _ := strconv.ParseInt(fields[1], 10, 64)
+			usage.CPUUsage = Duration{time.Duration(usec) * time.Microsecond}
+		}
+	}
+
+	return usage
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+func readCgroupFile(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
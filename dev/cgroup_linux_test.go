@@ -0,0 +1,127 @@
+package dev
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupConfig_cpuMax(t *testing.T) {
+	assert.Equal(t, "", CgroupConfig{}.cpuMax())
+	assert.Equal(t, "150000 100000", CgroupConfig{CPUMax: "150%"}.cpuMax())
+	assert.Equal(t, "50000 100000", CgroupConfig{CPUMax: "50000 100000"}.cpuMax())
+}
+
+// The cgroup filesystem is faked with a plain directory, which is enough to
+// check what puma-dev writes and reads.
+func TestCreateCgroup(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	root := filepath.Join("tmp", "cgroups", "puma-dev")
+
+	pool := &AppPool{}
+	assert.NoError(t, pool.SetupCgroups(root))
+	assert.Equal(t, root, pool.CgroupRoot)
+	assert.Equal(t, "+pids", readCgroupFile(root, "cgroup.subtree_control"))
+
+	cg, err := pool.createCgroup("myapp", CgroupConfig{MemoryMax: "1G", CPUMax: "200%"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, cg.join(1234))
+
+	dir := filepath.Join(root, "myapp")
+	assert.Equal(t, "1G", readCgroupFile(dir, "memory.max"))
+	assert.Equal(t, "200000 100000", readCgroupFile(dir, "cpu.max"))
+	assert.Equal(t, "max", readCgroupFile(dir, "pids.max"))
+	assert.Equal(t, "1234", readCgroupFile(dir, "cgroup.procs"))
+
+	writeFileOrFail(t, filepath.Join(dir, "memory.current"), "4096\n")
+	writeFileOrFail(t, filepath.Join(dir, "cpu.stat"), "usage_usec 1500000\nuser_usec 1000000\n")
+
+	usage := cg.usage()
+	assert.Equal(t, dir, usage.Path)
+	assert.Equal(t, uint64(4096), usage.MemoryCurrent)
+	assert.Equal(t, 1500*time.Millisecond, usage.CPUUsage.Duration)
+	assert.Equal(t, "1G", usage.MemoryMax)
+
+	for _, name := range []string{"memory.max", "cpu.max", "pids.max", "cgroup.procs", "memory.current", "cpu.stat"} {
+		os.Remove(filepath.Join(dir, name))
+	}
+
+	assert.NoError(t, cg.remove())
+
+	_, err = ioutil.ReadDir(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCgroup_wrap(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	cg := &cgroup{path: filepath.Join("tmp", "myapp")}
+	MakeDirectoryOrFail(t, cg.path)
+
+	cmd := exec.Command("sh", "-c", `echo "$0 $1"`, "hello", "world")
+	cg.wrap(cmd)
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world\n", string(out))
+	assert.Equal(t, strconv.Itoa(cmd.Process.Pid), readCgroupFile(cg.path, "cgroup.procs"))
+
+	cmd = exec.Command("true")
+	(*cgroup)(nil).wrap(cmd)
+	assert.Equal(t, []string{"true"}, cmd.Args)
+}
+
+// Sidecars are wrapped like the app, so the group already holds their
+// shell's pid when the command runs.
+func TestStartSidecars_cgroup(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	dir, err := filepath.Abs(filepath.Join("tmp", "sidecar-cgroup"))
+	assert.NoError(t, err)
+
+	cg := &cgroup{path: filepath.Join(dir, "cgroup")}
+	MakeDirectoryOrFail(t, cg.path)
+
+	app := &App{
+		Name:   "app",
+		Events: &Events{},
+		Config: &AppConfig{StopTimeout: Duration{time.Second}},
+		dir:    dir,
+		cgroup: cg,
+	}
+
+	app.startSidecars("/bin/bash", true, []ProcfileEntry{
+		{Name: "worker", Command: `echo "in $(cat "$PROCS")"`},
+	}, append(os.Environ(), "PROCS="+filepath.Join(cg.path, "cgroup.procs")))
+
+	if !assert.Len(t, app.sidecars, 1) {
+		return
+	}
+
+	sc := app.sidecars[0]
+	<-sc.done
+
+	assert.Equal(t, "/bin/sh", sc.cmd.Path)
+	assert.Contains(t, app.Log(), "[worker] in "+strconv.Itoa(sc.cmd.Process.Pid))
+}
+
+func TestCreateCgroup_disabled(t *testing.T) {
+	pool := &AppPool{}
+
+	cg, err := pool.createCgroup("myapp", CgroupConfig{MemoryMax: "1G"})
+	assert.NoError(t, err)
+	assert.Nil(t, cg)
+
+	assert.NoError(t, cg.join(1234))
+	assert.NoError(t, cg.remove())
+	assert.Nil(t, cg.usage())
+}
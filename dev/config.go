@@ -0,0 +1,305 @@
+package dev
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/vektra/errors"
+)
+
+// AppConfigFile is the name of the optional config file read from an
+// app's directory.
+const AppConfigFile = ".puma-dev.toml"
+
+// DefaultRestartDebounce is how long restart triggers must be quiet before
+// the app restarts, so a burst of changes only restarts it once.
+const DefaultRestartDebounce = 500 * time.Millisecond
+
+// DefaultStopTimeout is how long a stopping app gets before it is killed.
+const DefaultStopTimeout = 10 * time.Second
+
+// Duration wraps time.Duration so it can be written as "30s" or "15m" in
+// config files and shown the same way in /status.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	dur, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	d.Duration = dur
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// ByteSize is a size in bytes that can be written as "512MB" or "2GB" in
+// config files. Units are powers of 1024.
+type ByteSize uint64
+
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	str := strings.ToUpper(strings.TrimSpace(string(text)))
+	str = strings.Replace(str, "IB", "B", 1)
+
+	unit := ByteSize(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			unit = u.size
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size: %s", text)
+	}
+
+	*b = ByteSize(n * float64(unit))
+	return nil
+}
+
+// Set lets a ByteSize be used as a command line flag.
+func (b *ByteSize) Set(value string) error {
+	return b.UnmarshalText([]byte(value))
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range byteUnits[:4] {
+		if b >= u.size {
+			n := math.Round(float64(b)/float64(u.size)*10) / 10
+			return strconv.FormatFloat(n, 'f', -1, 64) + u.suffix
+		}
+	}
+
+	return strconv.FormatUint(uint64(b), 10) + "B"
+}
+
+// AppConfig holds the per-app settings. Values come from the pool defaults,
+// then .puma-dev.toml in the app directory, then ~/.puma-dev/<name>.toml,
+// each one overriding the keys it sets.
+type AppConfig struct {
+	Threads     int               `toml:"threads" json:"threads"`
+	Workers     int               `toml:"workers" json:"workers"`
+	Config      string            `toml:"config" json:"config"`
+	IdleTime    Duration          `toml:"idle_timeout" json:"idle_timeout"`
+	BootTimeout Duration          `toml:"boot_timeout" json:"boot_timeout"`
+	StopTimeout Duration          `toml:"stop_timeout" json:"stop_timeout"`
+	KeepWarm    bool              `toml:"keep_warm" json:"keep_warm"`
+	MaxRSS      ByteSize          `toml:"max_rss" json:"max_rss,omitempty"`
+	Env         map[string]string `toml:"env" json:"env,omitempty"`
+
+	// Command replaces puma with a custom command. Procfile names a Procfile
+	// whose web entry is used instead. Either one gets $SOCKET to bind to, or
+	// $PORT when Listen is "tcp".
+	Command  string `toml:"command" json:"command,omitempty"`
+	Procfile string `toml:"procfile" json:"procfile,omitempty"`
+	Listen   string `toml:"listen" json:"listen"`
+
+	// Sidecars are extra processes started and stopped with the app, by
+	// name. ProcfileSidecars names a Procfile whose entries other than web
+	// are run as sidecars too.
+	Sidecars         map[string]string `toml:"sidecars" json:"sidecars,omitempty"`
+	ProcfileSidecars string            `toml:"procfile_sidecars" json:"procfile_sidecars,omitempty"`
+
+	Readiness ReadinessConfig `toml:"readiness" json:"readiness"`
+
+	// Cgroup limits are only applied on Linux when puma-dev runs with
+	// -cgroups.
+	Cgroup CgroupConfig `toml:"cgroup" json:"cgroup"`
+
+	// RestartTriggers are globs, relative to the app directory, of files
+	// that restart the app when they change, on top of tmp/restart.txt.
+	RestartTriggers []string `toml:"restart_triggers" json:"restart_triggers,omitempty"`
+	RestartDebounce Duration `toml:"restart_debounce" json:"restart_debounce"`
+	RestartStrategy string   `toml:"restart_strategy" json:"restart_strategy"`
+
+	// Profiles are named sets of env overrides. A variant host such as
+	// myapp--test runs the app with the env of its "test" profile, which is
+	// recorded in Profile.
+	Profiles map[string]ProfileConfig `toml:"profiles" json:"profiles,omitempty"`
+	Profile  string                   `toml:"-" json:"profile,omitempty"`
+
+	// Files lists the config files that were found and merged.
+	Files []string `toml:"-" json:"files,omitempty"`
+}
+
+// ProfileConfig is a named profile of the per-app config.
+type ProfileConfig struct {
+	Env map[string]string `toml:"env" json:"env,omitempty"`
+}
+
+func (pool *AppPool) defaultConfig() *AppConfig {
+	return &AppConfig{
+		Threads:         DefaultThreads,
+		Workers:         0,
+		Config:          "-",
+		IdleTime:        Duration{pool.IdleTime},
+		BootTimeout:     Duration{pool.BootTimeout},
+		StopTimeout:     Duration{pool.StopTimeout},
+		RestartDebounce: Duration{DefaultRestartDebounce},
+		RestartStrategy: RestartKill,
+		Listen:          ListenUnix,
+		Readiness: ReadinessConfig{
+			Status:   http.StatusOK,
+			Interval: Duration{DefaultProbeInterval},
+		},
+	}
+}
+
+// LoadConfig reads the config for the app linked at dir, which is the path
+// of the app inside the pool directory.
+func (pool *AppPool) LoadConfig(dir string) (*AppConfig, error) {
+	cfg := pool.defaultConfig()
+
+	paths := []string{
+		filepath.Join(dir, AppConfigFile),
+		filepath.Clean(dir) + ".toml",
+	}
+
+	for _, path := range paths {
+		_, err := toml.DecodeFile(path, cfg)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, errors.Context(err, "reading "+path)
+		}
+
+		cfg.Files = append(cfg.Files, path)
+	}
+
+	switch cfg.RestartStrategy {
+	case RestartKill, RestartBlueGreen:
+	case RestartPhased, RestartHot:
+		// The signals restart puma, other commands may just exit on them.
+		if cfg.Command != "" || cfg.Procfile != "" {
+			return nil, fmt.Errorf("restart_strategy '%s' in %s only works with puma, not a command or procfile", cfg.RestartStrategy, dir)
+		}
+	default:
+		return nil, fmt.Errorf("unknown restart_strategy '%s' in %s", cfg.RestartStrategy, dir)
+	}
+
+	if cfg.Listen != ListenUnix && cfg.Listen != ListenTCP {
+		return nil, fmt.Errorf("unknown listen '%s' in %s, expected unix or tcp", cfg.Listen, dir)
+	}
+
+	if cfg.StopTimeout.Duration <= 0 {
+		cfg.StopTimeout.Duration = DefaultStopTimeout
+	}
+
+	if cfg.Readiness.Status == 0 {
+		cfg.Readiness.Status = http.StatusOK
+	}
+
+	if cfg.Readiness.Interval.Duration <= 0 {
+		cfg.Readiness.Interval.Duration = DefaultProbeInterval
+	}
+
+	return cfg, nil
+}
+
+// ApplyProfile merges the env of the named profile into the config.
+func (cfg *AppConfig) ApplyProfile(name string) error {
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile '%s'", name)
+	}
+
+	env := map[string]string{}
+	for k, v := range cfg.Env {
+		env[k] = v
+	}
+	for k, v := range profile.Env {
+		env[k] = v
+	}
+
+	cfg.Env = env
+	cfg.Profile = name
+
+	return nil
+}
+
+// WebCommand returns the custom command to launch the app with, or "" when
+// the app should be booted with puma.
+func (cfg *AppConfig) WebCommand(dir string) (string, error) {
+	if cfg.Command != "" {
+		return cfg.Command, nil
+	}
+
+	if cfg.Procfile == "" {
+		return "", nil
+	}
+
+	path := cfg.Procfile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	entries, err := ReadProcfile(path)
+	if err != nil {
+		return "", errors.Context(err, "reading "+path)
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "web" {
+			return entry.Command, nil
+		}
+	}
+
+	return "", fmt.Errorf("no web entry in %s", path)
+}
+
+// Environ returns the variables puma-dev passes to the app's shell.
+func (cfg *AppConfig) Environ() []string {
+	env := []string{
+		"THREADS=" + strconv.Itoa(cfg.Threads),
+		"WORKERS=" + strconv.Itoa(cfg.Workers),
+		"CONFIG=" + cfg.Config,
+	}
+
+	var keys []string
+	for k := range cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		env = append(env, k+"="+cfg.Env[k])
+	}
+
+	return env
+}
@@ -0,0 +1,216 @@
+package dev
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFileOrFail(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+}
+
+// writeAppOrFail makes dir an app that accepts connections on $SOCKET
+// without needing puma, with config as its .puma-dev.toml.
+func writeAppOrFail(t *testing.T, dir, config string) {
+	MakeDirectoryOrFail(t, filepath.Join(dir, "tmp"))
+
+	writeFileOrFail(t, filepath.Join(dir, "server.py"), `import os, socket
+s = socket.socket(socket.AF_UNIX)
+s.bind(os.environ["SOCKET"])
+s.listen(5)
+while True:
+    s.accept()[0].close()
+`)
+
+	writeFileOrFail(t, filepath.Join(dir, AppConfigFile), "command = \"exec python3 server.py\"\n"+config)
+}
+
+func TestLoadConfig_defaults(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "config-defaults")
+	MakeDirectoryOrFail(t, appDir)
+
+	pool := &AppPool{IdleTime: 15 * time.Minute}
+
+	cfg, err := pool.LoadConfig(appDir)
+	assert.NoError(t, err)
+
+	assert.Equal(t, DefaultThreads, cfg.Threads)
+	assert.Equal(t, 0, cfg.Workers)
+	assert.Equal(t, "-", cfg.Config)
+	assert.Equal(t, 15*time.Minute, cfg.IdleTime.Duration)
+	assert.Empty(t, cfg.Files)
+	assert.Equal(t, []string{"THREADS=5", "WORKERS=0", "CONFIG=-"}, cfg.Environ())
+}
+
+func TestLoadConfig_merged(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "config-merged")
+	MakeDirectoryOrFail(t, appDir)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `
+threads = 2
+workers = 1
+config = "config/puma/dev.rb"
+idle_timeout = "1h"
+
+[env]
+RAILS_ENV = "development"
+`)
+
+	writeFileOrFail(t, appDir+".toml", `
+workers = 3
+
+[env]
+DEBUG = "1"
+`)
+
+	pool := &AppPool{IdleTime: 15 * time.Minute}
+
+	cfg, err := pool.LoadConfig(appDir)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, cfg.Threads)
+	assert.Equal(t, 3, cfg.Workers)
+	assert.Equal(t, "config/puma/dev.rb", cfg.Config)
+	assert.Equal(t, time.Hour, cfg.IdleTime.Duration)
+	assert.Len(t, cfg.Files, 2)
+	assert.Equal(t, []string{
+		"THREADS=2",
+		"WORKERS=3",
+		"CONFIG=config/puma/dev.rb",
+		"DEBUG=1",
+		"RAILS_ENV=development",
+	}, cfg.Environ())
+}
+
+func TestLoadConfig_invalid(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "config-invalid")
+	MakeDirectoryOrFail(t, appDir)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `threads = "many"`)
+
+	pool := &AppPool{}
+
+	_, err := pool.LoadConfig(appDir)
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_restartStrategy(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "config-restart-strategy")
+	MakeDirectoryOrFail(t, appDir)
+
+	pool := &AppPool{}
+
+	cfg, err := pool.LoadConfig(appDir)
+	assert.NoError(t, err)
+	assert.Equal(t, RestartKill, cfg.RestartStrategy)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `restart_strategy = "blue-green"`)
+
+	cfg, err = pool.LoadConfig(appDir)
+	assert.NoError(t, err)
+	assert.Equal(t, RestartBlueGreen, cfg.RestartStrategy)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `restart_strategy = "sideways"`)
+
+	_, err = pool.LoadConfig(appDir)
+	assert.Error(t, err)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), "restart_strategy = \"phased\"\ncommand = \"bin/webpack-dev-server\"")
+
+	_, err = pool.LoadConfig(appDir)
+	assert.EqualError(t, err, "restart_strategy 'phased' in "+appDir+" only works with puma, not a command or procfile")
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), "restart_strategy = \"hot\"\nprocfile = \"Procfile\"")
+
+	_, err = pool.LoadConfig(appDir)
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_listen(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "config-listen")
+	MakeDirectoryOrFail(t, appDir)
+
+	pool := &AppPool{}
+
+	cfg, err := pool.LoadConfig(appDir)
+	assert.NoError(t, err)
+	assert.Equal(t, ListenUnix, cfg.Listen)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `listen = "tcp"`)
+
+	cfg, err = pool.LoadConfig(appDir)
+	assert.NoError(t, err)
+	assert.Equal(t, ListenTCP, cfg.Listen)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `listen = "udp"`)
+
+	_, err = pool.LoadConfig(appDir)
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_profile(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "config-profile")
+	MakeDirectoryOrFail(t, appDir)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `
+[env]
+RAILS_ENV = "development"
+DEBUG = "1"
+
+[profiles.test.env]
+RAILS_ENV = "test"
+`)
+
+	pool := &AppPool{}
+
+	cfg, err := pool.LoadConfig(appDir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cfg.ApplyProfile("test"))
+	assert.Equal(t, "test", cfg.Profile)
+	assert.Equal(t, map[string]string{"RAILS_ENV": "test", "DEBUG": "1"}, cfg.Env)
+
+	assert.EqualError(t, cfg.ApplyProfile("staging"), "unknown profile 'staging'")
+}
+
+func TestByteSize(t *testing.T) {
+	for text, size := range map[string]ByteSize{
+		"1024":   1024,
+		"512MB":  512 << 20,
+		"512mb":  512 << 20,
+		"2G":     2 << 30,
+		"1.5GiB": 3 << 29,
+		"10 KB":  10 << 10,
+	} {
+		var b ByteSize
+		assert.NoError(t, b.UnmarshalText([]byte(text)), text)
+		assert.Equal(t, size, b, text)
+	}
+
+	var b ByteSize
+	assert.Error(t, b.UnmarshalText([]byte("lots")))
+	assert.Error(t, b.UnmarshalText([]byte("-1GB")))
+
+	assert.Equal(t, "1.5GB", ByteSize(3<<29).String())
+	assert.Equal(t, "512MB", ByteSize(512<<20).String())
+	assert.Equal(t, "100B", ByteSize(100).String())
+}
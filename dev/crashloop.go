@@ -0,0 +1,181 @@
+package dev
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// CrashWindow is how long an app must stay up after booting before an
+	// exit is no longer counted as a boot failure.
+	CrashWindow = 10 * time.Second
+
+	minCrashBackoff = 1 * time.Second
+	maxCrashBackoff = 1 * time.Minute
+
+	// crashLoopReset forgets earlier failures if the app has not crashed
+	// for a while.
+	crashLoopReset = 5 * time.Minute
+)
+
+// CrashLoopError is returned when an app keeps failing to boot and puma-dev
+// is waiting before trying to launch it again.
+type CrashLoopError struct {
+	Name     string
+	Failures int
+	Until    time.Time
+	Failure  *BootFailure
+}
+
+func (e *CrashLoopError) Error() string {
+	return fmt.Sprintf("app '%s' keeps crashing, retrying in %s",
+		e.Name, time.Until(e.Until).Round(time.Second))
+}
+
+type crashLoop struct {
+	failures   int
+	lastFailed time.Time
+	until      time.Time
+	failure    *BootFailure
+	restartTxt time.Time
+}
+
+func restartTxtModTime(dir string) time.Time {
+	stat, err := os.Stat(filepath.Join(dir, "tmp", "restart.txt"))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return stat.ModTime()
+}
+
+// crashed reports whether the app exited on its own during or right after
+// booting.
+func (a *App) crashed() bool {
+	a.lock.Lock()
+	stopping := a.stopping
+	a.lock.Unlock()
+
+	if stopping {
+		return false
+	}
+
+	select {
+	case <-a.readyChan:
+		return time.Since(a.readyAt) < CrashWindow
+	default:
+		return true
+	}
+}
+
+func (pool *AppPool) recordCrash(app *App, failure *BootFailure) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if pool.crashes == nil {
+		pool.crashes = make(map[string]*crashLoop)
+	}
+
+	cl, ok := pool.crashes[app.Name]
+	if !ok || time.Since(cl.lastFailed) > crashLoopReset {
+		cl = &crashLoop{}
+		pool.crashes[app.Name] = cl
+	}
+
+	backoff := minCrashBackoff << uint(cl.failures)
+	if backoff > maxCrashBackoff || backoff <= 0 {
+		backoff = maxCrashBackoff
+	}
+
+	cl.failures++
+	cl.lastFailed = time.Now()
+	cl.until = cl.lastFailed.Add(backoff)
+	cl.failure = failure
+	cl.restartTxt = restartTxtModTime(app.dir)
+
+	app.eventAdd("crash_loop",
+		"failures", cl.failures,
+		"backoff", backoff.String(),
+	)
+
+	fmt.Printf("! App '%s' crashed %d time(s), waiting %s before booting it again\n",
+		app.Name, cl.failures, backoff)
+}
+
+func (pool *AppPool) forgetCrashes(name string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	delete(pool.crashes, name)
+}
+
+// checkCrashLoop must be called with the pool locked.
+func (pool *AppPool) checkCrashLoop(name, dir string) error {
+	cl, ok := pool.crashes[name]
+	if !ok {
+		return nil
+	}
+
+	if time.Now().After(cl.until) {
+		return nil
+	}
+
+	if restartTxtModTime(dir).After(cl.restartTxt) {
+		pool.Events.Add("crash_loop_reset", "app", name, "reason", "restart.txt touched")
+		delete(pool.crashes, name)
+		return nil
+	}
+
+	return &CrashLoopError{
+		Name:     name,
+		Failures: cl.failures,
+		Until:    cl.until,
+		Failure:  cl.failure,
+	}
+}
+
+// ResetCrashLoop clears the backoff for the named app so the next request
+// boots it right away. name can be any name the app is reachable by, such
+// as the name of a link to it. It returns false if the app was not backing
+// off.
+func (pool *AppPool) ResetCrashLoop(name string) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	name = pool.crashName(name)
+
+	if _, ok := pool.crashes[name]; !ok {
+		return false
+	}
+
+	delete(pool.crashes, name)
+	pool.Events.Add("crash_loop_reset", "app", name, "reason", "reset requested")
+
+	return true
+}
+
+// crashName returns the canonical name crashes of the app reachable as
+// name are recorded under. It must be called with the pool locked.
+func (pool *AppPool) crashName(name string) string {
+	if _, ok := pool.crashes[name]; ok {
+		return name
+	}
+
+	if app, ok := pool.apps[name]; ok {
+		return app.Name
+	}
+
+	if base, profile := splitVariant(name); profile != "" {
+		if _, _, canonicalName, _, err := pool.resolveApp(base); err == nil {
+			return canonicalName + VariantSeparator + profile
+		}
+	}
+
+	if _, _, canonicalName, _, err := pool.resolveApp(name); err == nil {
+		return canonicalName
+	}
+
+	return name
+}
@@ -0,0 +1,80 @@
+package dev
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppPool_crashLoop(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "crashy")
+	MakeDirectoryOrFail(t, filepath.Join(appDir, "tmp"))
+
+	pool := &AppPool{Events: &Events{}}
+	app := &App{Name: "crashy", dir: appDir, pool: pool, Events: pool.Events}
+	failure := &BootFailure{App: "crashy"}
+
+	assert.NoError(t, pool.checkCrashLoop("crashy", appDir))
+
+	pool.recordCrash(app, failure)
+	pool.recordCrash(app, failure)
+
+	err := pool.checkCrashLoop("crashy", appDir)
+	if assert.IsType(t, &CrashLoopError{}, err) {
+		cle := err.(*CrashLoopError)
+		assert.Equal(t, 2, cle.Failures)
+		assert.Equal(t, failure, cle.Failure)
+		assert.WithinDuration(t, time.Now().Add(2*time.Second), cle.Until, time.Second)
+	}
+
+	assert.True(t, pool.ResetCrashLoop("crashy"))
+	assert.False(t, pool.ResetCrashLoop("crashy"))
+	assert.NoError(t, pool.checkCrashLoop("crashy", appDir))
+
+	pool.recordCrash(app, failure)
+	assert.Error(t, pool.checkCrashLoop("crashy", appDir))
+
+	restartTxt := filepath.Join(appDir, "tmp", "restart.txt")
+	writeFileOrFail(t, restartTxt, "")
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(restartTxt, future, future))
+
+	assert.NoError(t, pool.checkCrashLoop("crashy", appDir), "touching restart.txt clears the backoff")
+}
+
+func TestAppPool_resetCrashLoopLink(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir, err := filepath.Abs(filepath.Join("tmp", "crashy"))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	MakeDirectoryOrFail(t, appDir)
+
+	poolDir := filepath.Join("tmp", "pool")
+	MakeDirectoryOrFail(t, poolDir)
+
+	if err := os.Symlink(appDir, filepath.Join(poolDir, "myapp")); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	canonicalName := fmt.Sprintf("crashy-%.4x", sha1.Sum([]byte(appDir)))
+
+	pool := &AppPool{Dir: poolDir, Events: &Events{}}
+	app := &App{Name: canonicalName, dir: appDir, pool: pool, Events: pool.Events}
+
+	pool.recordCrash(app, &BootFailure{App: canonicalName})
+
+	assert.False(t, pool.ResetCrashLoop("otherapp"))
+	assert.True(t, pool.ResetCrashLoop("myapp"))
+	assert.NoError(t, pool.checkCrashLoop(canonicalName, appDir))
+}
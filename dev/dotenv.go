@@ -0,0 +1,548 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vektra/errors"
+)
+
+// DotenvVar is a single assignment from a dotenv file. The value is kept as
+// parts so references to other variables can be expanded by the shell
+// wrapper, after the login shell has set up the environment.
+type DotenvVar struct {
+	Name  string
+	Value []DotenvPart
+}
+
+// DotenvPart is either literal text, or the name of a variable to expand
+// when Ref is set. A reference with an Op, one of ":-", "-", ":=" or "=",
+// falls back to Default like the shell's ${NAME:-default} does.
+type DotenvPart struct {
+	Text string
+	Ref  bool
+
+	Op      string
+	Default []DotenvPart
+}
+
+// dotenvFiles are the dotenv files in an app directory, in the order the
+// shell wrapper loads them, with the variable that turns each one off and
+// the variable its exports are passed to the wrapper in.
+var dotenvFiles = []struct {
+	name, toggle, variable string
+}{
+	{".env", "PUMADEV_SOURCE_ENV", "PUMADEV_DOTENV_ENV"},
+	{".powenv", "PUMADEV_SOURCE_POWENV", "PUMADEV_DOTENV_POWENV"},
+	{".pumaenv", "PUMADEV_SOURCE_PUMAENV", "PUMADEV_DOTENV_PUMAENV"},
+}
+
+// ParseDotenv reads the assignments of a dotenv file in the order they
+// appear. It understands comments, an optional export prefix, single
+// quoted values which are taken literally, double quoted values with
+// escapes, and $VAR, ${VAR}, ${VAR:-default} or ${VAR:=default} references
+// in double quoted and unquoted values. Quoted values may span several
+// lines. Command substitution is an error rather than being kept as text,
+// since the shell would have run it.
+func ParseDotenv(r io.Reader) ([]DotenvVar, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &dotenvParser{
+		src:  strings.Replace(string(data), "\r\n", "\n", -1),
+		line: 1,
+	}
+
+	return p.parse()
+}
+
+// ReadDotenv parses the dotenv file at path.
+func ReadDotenv(path string) ([]DotenvVar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars, err := ParseDotenv(f)
+	if err != nil {
+		return nil, errors.Context(err, "parsing "+path)
+	}
+
+	return vars, nil
+}
+
+// dotenvEnv parses the dotenv files in dir and returns the variables that
+// pass their exports to the shell wrapper. Files turned off in env are
+// skipped. The toggles can also be set by files the shell sources, such as
+// ~/.powconfig, so a file that can't be parsed only fails the app once the
+// shell wrapper gets to it.
+func dotenvEnv(dir string, env []string) []string {
+	var result []string
+
+	for _, file := range dotenvFiles {
+		if lookupEnv(env, file.toggle) == "0" {
+			continue
+		}
+
+		vars, err := ReadDotenv(filepath.Join(dir, file.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			result = append(result, file.variable+"="+dotenvErrorScript(err))
+			continue
+		}
+
+		var script strings.Builder
+		for _, v := range vars {
+			script.WriteString(v.shellExport())
+		}
+
+		if script.Len() > 0 {
+			result = append(result, file.variable+"="+script.String())
+		}
+	}
+
+	return result
+}
+
+// dotenvErrorScript returns a script that reports err and exits, in place
+// of the exports of a dotenv file.
+func dotenvErrorScript(err error) string {
+	msg := "! Unable to load env: " + err.Error()
+
+	return "echo '" + strings.Replace(msg, "'", `'\''`, -1) + "' >&2\nexit 1\n"
+}
+
+// lookupEnv returns the last value of name in env, like the process would
+// see it.
+func lookupEnv(env []string, name string) string {
+	var value string
+
+	for _, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			value = kv[len(name)+1:]
+		}
+	}
+
+	return value
+}
+
+// shellExport returns a bash export statement for v. Literal text is
+// escaped so only the variable references are expanded.
+func (v DotenvVar) shellExport() string {
+	var buf strings.Builder
+
+	buf.WriteString("export ")
+	buf.WriteString(v.Name)
+	buf.WriteString(`="`)
+	writeShellParts(&buf, v.Value, false)
+	buf.WriteString("\"\n")
+
+	return buf.String()
+}
+
+// writeShellParts writes parts for use inside double quotes. A } in the
+// default of a reference would end it early, so it is escaped there too.
+func writeShellParts(buf *strings.Builder, parts []DotenvPart, inRef bool) {
+	for _, part := range parts {
+		if part.Ref {
+			buf.WriteString("${" + part.Text + part.Op)
+			writeShellParts(buf, part.Default, true)
+			buf.WriteString("}")
+			continue
+		}
+
+		for _, r := range part.Text {
+			switch {
+			case r == '\\', r == '"', r == '$', r == '`', r == '}' && inRef:
+				buf.WriteRune('\\')
+			}
+			buf.WriteRune(r)
+		}
+	}
+}
+
+type dotenvParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *dotenvParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *dotenvParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *dotenvParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func (p *dotenvParser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+
+	if c == '\n' {
+		p.line++
+	}
+
+	return c
+}
+
+func (p *dotenvParser) skipSpaces() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.next()
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for !p.eof() && p.peek() != '\n' {
+		p.next()
+	}
+}
+
+func (p *dotenvParser) readName() string {
+	start := p.pos
+
+	for !p.eof() && isNameChar(p.peek(), p.pos == start) {
+		p.next()
+	}
+
+	return p.src[start:p.pos]
+}
+
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+
+	return false
+}
+
+func (p *dotenvParser) parse() ([]DotenvVar, error) {
+	var vars []DotenvVar
+
+	for {
+		for p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n' {
+			p.next()
+		}
+
+		if p.eof() {
+			return vars, nil
+		}
+
+		if p.peek() == '#' {
+			p.skipLine()
+			continue
+		}
+
+		name := p.readName()
+
+		exported := false
+		if name == "export" && (p.peek() == ' ' || p.peek() == '\t') {
+			exported = true
+			p.skipSpaces()
+			name = p.readName()
+		}
+
+		if name == "" {
+			return nil, p.errorf("expected a variable name")
+		}
+
+		p.skipSpaces()
+
+		// "export NAME" on its own marks an existing variable for export,
+		// which every variable already is.
+		if exported && (p.eof() || p.peek() == '\n' || p.peek() == '#') {
+			p.skipLine()
+			continue
+		}
+
+		if p.peek() != '=' {
+			return nil, p.errorf("expected '=' after %s", name)
+		}
+
+		p.next()
+		p.skipSpaces()
+
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpaces()
+
+		if p.peek() == '#' {
+			p.skipLine()
+		}
+
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("unexpected %q after the value of %s", p.peek(), name)
+		}
+
+		vars = append(vars, DotenvVar{Name: name, Value: value})
+	}
+}
+
+func (p *dotenvParser) readValue() ([]DotenvPart, error) {
+	switch p.peek() {
+	case '\'':
+		return p.readSingleQuoted()
+	case '"':
+		return p.readDoubleQuoted()
+	default:
+		return p.readUnquoted()
+	}
+}
+
+func (p *dotenvParser) readSingleQuoted() ([]DotenvPart, error) {
+	line := p.line
+	p.next()
+
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' {
+		p.next()
+	}
+
+	if p.eof() {
+		return nil, fmt.Errorf("line %d: unterminated single quote", line)
+	}
+
+	text := p.src[start:p.pos]
+	p.next()
+
+	return []DotenvPart{{Text: text}}, nil
+}
+
+func (p *dotenvParser) readDoubleQuoted() ([]DotenvPart, error) {
+	line := p.line
+	p.next()
+
+	var (
+		parts []DotenvPart
+		text  strings.Builder
+	)
+
+	for {
+		if p.eof() {
+			return nil, fmt.Errorf("line %d: unterminated double quote", line)
+		}
+
+		c := p.next()
+
+		switch c {
+		case '"':
+			return appendText(parts, &text), nil
+		case '\\':
+			if p.eof() {
+				continue
+			}
+
+			switch e := p.next(); e {
+			case 'n':
+				text.WriteByte('\n')
+			case 't':
+				text.WriteByte('\t')
+			case 'r':
+				text.WriteByte('\r')
+			case '"', '\\', '$', '`':
+				text.WriteByte(e)
+			default:
+				text.WriteByte('\\')
+				text.WriteByte(e)
+			}
+		case '$':
+			ref, ok, err := p.readRef()
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				parts = appendText(parts, &text)
+				parts = append(parts, ref)
+			} else {
+				text.WriteByte('$')
+			}
+		case '`':
+			return nil, p.errorf("command substitution with backticks is not supported")
+		default:
+			text.WriteByte(c)
+		}
+	}
+}
+
+func (p *dotenvParser) readUnquoted() ([]DotenvPart, error) {
+	var (
+		parts []DotenvPart
+		text  strings.Builder
+	)
+
+	for !p.eof() && p.peek() != '\n' {
+		c := p.peek()
+
+		// A # only starts a comment after whitespace, so values like
+		// colors or URL fragments don't need quoting.
+		if c == '#' && p.pos > 0 && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t') {
+			break
+		}
+
+		p.next()
+
+		switch c {
+		case '\\':
+			switch e := p.peek(); e {
+			case '$', '\\', '#':
+				text.WriteByte(p.next())
+			default:
+				text.WriteByte('\\')
+			}
+		case '$':
+			ref, ok, err := p.readRef()
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				parts = appendText(parts, &text)
+				parts = append(parts, ref)
+			} else {
+				text.WriteByte('$')
+			}
+		case '`':
+			return nil, p.errorf("command substitution with backticks is not supported")
+		default:
+			text.WriteByte(c)
+		}
+	}
+
+	parts = appendText(parts, &text)
+
+	if n := len(parts); n > 0 && !parts[n-1].Ref {
+		parts[n-1].Text = strings.TrimRight(parts[n-1].Text, " \t")
+		if parts[n-1].Text == "" {
+			parts = parts[:n-1]
+		}
+	}
+
+	return parts, nil
+}
+
+// readRef reads a variable reference after a $. ok is false if the $
+// doesn't start a reference.
+func (p *dotenvParser) readRef() (ref DotenvPart, ok bool, err error) {
+	switch p.peek() {
+	case '(':
+		return ref, false, p.errorf("command substitution with $(...) is not supported")
+	case '{':
+		p.next()
+	default:
+		name := p.readName()
+		return DotenvPart{Text: name, Ref: true}, name != "", nil
+	}
+
+	ref = DotenvPart{Text: p.readName(), Ref: true}
+
+	colon := p.peek() == ':'
+	if colon {
+		p.next()
+	}
+
+	if c := p.peek(); c == '-' || c == '=' {
+		p.next()
+		ref.Op = string(c)
+		if colon {
+			ref.Op = ":" + ref.Op
+		}
+	}
+
+	if ref.Text == "" || ref.Op == "" && (colon || p.peek() != '}') {
+		return ref, false, p.errorf("invalid variable reference, expected ${NAME}, ${NAME:-default} or ${NAME:=default}")
+	}
+
+	if ref.Op != "" {
+		ref.Default, err = p.readDefault()
+		if err != nil {
+			return ref, false, err
+		}
+	}
+
+	p.next()
+
+	return ref, true, nil
+}
+
+// readDefault reads the default of a ${NAME:-default} reference, up to the
+// closing }.
+func (p *dotenvParser) readDefault() ([]DotenvPart, error) {
+	line := p.line
+
+	var (
+		parts []DotenvPart
+		text  strings.Builder
+	)
+
+	for {
+		if p.eof() {
+			return nil, fmt.Errorf("line %d: unterminated variable reference", line)
+		}
+
+		if p.peek() == '}' {
+			return appendText(parts, &text), nil
+		}
+
+		switch c := p.next(); c {
+		case '\\':
+			switch e := p.peek(); e {
+			case '$', '\\', '"', '`', '}':
+				text.WriteByte(p.next())
+			default:
+				text.WriteByte('\\')
+			}
+		case '$':
+			ref, ok, err := p.readRef()
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				parts = appendText(parts, &text)
+				parts = append(parts, ref)
+			} else {
+				text.WriteByte('$')
+			}
+		case '`':
+			return nil, p.errorf("command substitution with backticks is not supported")
+		case '"', '\'':
+			return nil, p.errorf("quotes in the default of a variable reference are not supported")
+		default:
+			text.WriteByte(c)
+		}
+	}
+}
+
+func appendText(parts []DotenvPart, text *strings.Builder) []DotenvPart {
+	if text.Len() > 0 {
+		parts = append(parts, DotenvPart{Text: text.String()})
+		text.Reset()
+	}
+
+	return parts
+}
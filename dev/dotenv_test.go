@@ -0,0 +1,138 @@
+package dev
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDotenv(t *testing.T) {
+	vars, err := ParseDotenv(strings.NewReader(`
+# database
+export DATABASE_URL=postgres://localhost/app?sslmode=disable&pool=5
+SECRET_KEY_BASE = abc123 # inline comment
+COLOR=#fff
+GREETING="hello\n\"world\""
+LITERAL='$HOME stays (as is)'
+MULTI="one
+two"
+PATH_PREFIX=${HOME}/bin:$PATH
+PRICE=\$5
+PORT=${PORT:-3000}
+HOST="${HOST:=$USER.local\}}"
+EMPTY=
+export HOME
+`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []DotenvVar{
+		{Name: "DATABASE_URL", Value: []DotenvPart{{Text: "postgres://localhost/app?sslmode=disable&pool=5"}}},
+		{Name: "SECRET_KEY_BASE", Value: []DotenvPart{{Text: "abc123"}}},
+		{Name: "COLOR", Value: []DotenvPart{{Text: "#fff"}}},
+		{Name: "GREETING", Value: []DotenvPart{{Text: "hello\n\"world\""}}},
+		{Name: "LITERAL", Value: []DotenvPart{{Text: "$HOME stays (as is)"}}},
+		{Name: "MULTI", Value: []DotenvPart{{Text: "one\ntwo"}}},
+		{Name: "PATH_PREFIX", Value: []DotenvPart{
+			{Text: "HOME", Ref: true},
+			{Text: "/bin:"},
+			{Text: "PATH", Ref: true},
+		}},
+		{Name: "PRICE", Value: []DotenvPart{{Text: "$5"}}},
+		{Name: "PORT", Value: []DotenvPart{
+			{Text: "PORT", Ref: true, Op: ":-", Default: []DotenvPart{{Text: "3000"}}},
+		}},
+		{Name: "HOST", Value: []DotenvPart{
+			{Text: "HOST", Ref: true, Op: ":=", Default: []DotenvPart{
+				{Text: "USER", Ref: true},
+				{Text: ".local}"},
+			}},
+		}},
+		{Name: "EMPTY"},
+	}, vars)
+}
+
+func TestParseDotenv_errors(t *testing.T) {
+	_, err := ParseDotenv(strings.NewReader("FOO=1\nBAR='unterminated\n"))
+	assert.EqualError(t, err, "line 2: unterminated single quote")
+
+	_, err = ParseDotenv(strings.NewReader("FOO bar"))
+	assert.EqualError(t, err, "line 1: expected '=' after FOO")
+
+	_, err = ParseDotenv(strings.NewReader(`FOO="a" b`))
+	assert.EqualError(t, err, `line 1: unexpected 'b' after the value of FOO`)
+
+	_, err = ParseDotenv(strings.NewReader("FOO=${BAR"))
+	assert.EqualError(t, err, "line 1: invalid variable reference, expected ${NAME}, ${NAME:-default} or ${NAME:=default}")
+
+	_, err = ParseDotenv(strings.NewReader("FOO=${BAR:}"))
+	assert.Error(t, err)
+
+	_, err = ParseDotenv(strings.NewReader("FOO=${BAR:-baz"))
+	assert.EqualError(t, err, "line 1: unterminated variable reference")
+
+	_, err = ParseDotenv(strings.NewReader(`FOO=${BAR:-"baz"}`))
+	assert.EqualError(t, err, "line 1: quotes in the default of a variable reference are not supported")
+
+	_, err = ParseDotenv(strings.NewReader("FOO=1\nBAR=$(whoami)"))
+	assert.EqualError(t, err, "line 2: command substitution with $(...) is not supported")
+
+	_, err = ParseDotenv(strings.NewReader("FOO=\"`whoami`\""))
+	assert.EqualError(t, err, "line 1: command substitution with backticks is not supported")
+}
+
+func TestDotenvEnv(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "dotenv")
+	MakeDirectoryOrFail(t, appDir)
+
+	writeFileOrFail(t, filepath.Join(appDir, ".env"), `
+TOKEN='a&b;c|d $(rm -rf /) `+"`id`"+`'
+QUOTED="it's \"fine\""
+BIN=$BASE/bin
+PORT=${PORT:-3000}
+HOST=${HOST:=local\}host}
+`)
+	writeFileOrFail(t, filepath.Join(appDir, ".pumaenv"), "EXTRA=1\n")
+
+	env := dotenvEnv(appDir, []string{"PUMADEV_SOURCE_PUMAENV=0"})
+	assert.Len(t, env, 1)
+
+	// The exports are expanded by bash, like the shell wrapper does.
+	cmd := exec.Command("bash", "-c", `eval "$PUMADEV_DOTENV_ENV"; printf '%s\n' "$TOKEN" "$QUOTED" "$BIN" "$PORT" "$HOST"`)
+	cmd.Env = append(env, "BASE=/opt")
+
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "a&b;c|d $(rm -rf /) `id`\nit's \"fine\"\n/opt/bin\n3000\nlocal}host\n", string(out))
+
+	writeFileOrFail(t, filepath.Join(appDir, ".env"), "BROKEN='\n")
+
+	assert.Empty(t, dotenvEnv(appDir, []string{"PUMADEV_SOURCE_ENV=0", "PUMADEV_SOURCE_PUMAENV=0"}))
+
+	// A file that can't be parsed fails the app only if the shell wrapper
+	// still sources it, since the toggles may be set by ~/.powconfig.
+	env = dotenvEnv(appDir, []string{"PUMADEV_SOURCE_PUMAENV=0"})
+	assert.Len(t, env, 1)
+
+	script := `eval "$PUMADEV_DOTENV_ENV"; echo sourced`
+
+	cmd = exec.Command("bash", "-c", script)
+	cmd.Env = env
+
+	out, err = cmd.CombinedOutput()
+	assert.Error(t, err)
+	assert.Contains(t, string(out), "! Unable to load env: parsing "+filepath.Join(appDir, ".env"))
+	assert.NotContains(t, string(out), "sourced")
+
+	cmd = exec.Command("bash", "-c", `PUMADEV_SOURCE_ENV=0; if [ "$PUMADEV_SOURCE_ENV" != "0" ]; then `+script+`; fi; echo skipped`)
+	cmd.Env = env
+
+	out, err = cmd.CombinedOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, "skipped\n", string(out))
+}
@@ -0,0 +1,220 @@
+package dev
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/puma/puma-dev/homedir"
+)
+
+// envCaptureScript is run by the shell wrapper right before the app unless
+// it is started from the cached environment. It writes the environment the app gets to
+// the file named by $PUMADEV_ENV_CAPTURE. A file is used rather than an
+// inherited fd because interactive shells are free to close those.
+const envCaptureScript = `env -0 > "$PUMADEV_ENV_CAPTURE"
+unset PUMADEV_ENV_CAPTURE
+`
+
+// envCacheAppFiles are files in the app directory that either get sourced
+// by the shell wrapper or decide what version managers put in the PATH.
+var envCacheAppFiles = []string{
+	".env", ".powrc", ".powenv", ".pumaenv",
+	".ruby-version", ".tool-versions", ".node-version", ".nvmrc", ".python-version",
+}
+
+// envCacheShellFiles are the shell startup files a login shell may read.
+var envCacheShellFiles = []string{
+	"~/.powconfig",
+	"~/.profile", "~/.bash_profile", "~/.bash_login", "~/.bashrc",
+	"~/.zshenv", "~/.zprofile", "~/.zshrc", "~/.zlogin",
+	"~/.config/fish/config.fish",
+	"/etc/profile", "/etc/bashrc", "/etc/bash.bashrc",
+	"/etc/zshenv", "/etc/zprofile", "/etc/zshrc",
+	"/etc/zsh/zshenv", "/etc/zsh/zprofile", "/etc/zsh/zshrc",
+}
+
+// perLaunchEnv are the variables set for each launch, which must not be
+// taken from the cache.
+var perLaunchEnv = []string{"SOCKET", "PORT", "PUMADEV_COMMAND", "PUMADEV_ENV_CAPTURE"}
+
+type envCacheEntry struct {
+	key string
+	env []string
+}
+
+// envCacheKey identifies the environment the shell wrapper would produce
+// for dir. It changes whenever the shell, the environment passed in, or any
+// file that may be sourced changes.
+func envCacheKey(shell, dir string, env []string) string {
+	h := sha1.New()
+
+	fmt.Fprintf(h, "%s\x00%s\x00", shell, dir)
+
+	for _, kv := range env {
+		fmt.Fprintf(h, "%s\x00", kv)
+	}
+
+	var paths []string
+	for _, name := range envCacheAppFiles {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	for _, path := range envCacheShellFiles {
+		if expanded, err := homedir.Expand(path); err == nil {
+			paths = append(paths, expanded)
+		}
+	}
+
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(h, "%s missing\n", path)
+		} else {
+			fmt.Fprintf(h, "%s %d %d\n", path, stat.ModTime().UnixNano(), stat.Size())
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// cachedEnv returns the cached environment for the named app if it is still
+// valid. Variants of an app have their own entries, since their env differs.
+func (pool *AppPool) cachedEnv(name, key string) ([]string, bool) {
+	pool.envLock.Lock()
+	defer pool.envLock.Unlock()
+
+	entry, ok := pool.envCache[name]
+	if !ok || entry.key != key {
+		return nil, false
+	}
+
+	return append([]string{}, entry.env...), true
+}
+
+// envCaptureFile creates the private file the environment is captured in.
+func envCaptureFile() (string, error) {
+	f, err := ioutil.TempFile("", "puma-dev-env-")
+	if err != nil {
+		return "", err
+	}
+
+	f.Close()
+
+	return f.Name(), nil
+}
+
+// saveEnv records the environment written to path by envCaptureScript on
+// the app, and caches it under key unless key is empty. It is written right
+// before the app starts, so it is read once the app is ready. Nothing is
+// saved if the app doesn't boot.
+func (pool *AppPool) saveEnv(app *App, key, path string) {
+	defer os.Remove(path)
+
+	select {
+	case <-app.readyChan:
+	case <-app.t.Dying():
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	var env []string
+	for _, kv := range bytes.Split(data, []byte{0}) {
+		if len(kv) > 0 && !isPerLaunchEnv(string(kv)) {
+			env = append(env, string(kv))
+		}
+	}
+
+	app.lock.Lock()
+	app.env = env
+	app.lock.Unlock()
+
+	if key == "" {
+		return
+	}
+
+	pool.envLock.Lock()
+	defer pool.envLock.Unlock()
+
+	if pool.envCache == nil {
+		pool.envCache = make(map[string]envCacheEntry)
+	}
+
+	pool.envCache[app.Name] = envCacheEntry{key: key, env: env}
+
+	app.eventAdd("env_cached", "vars", len(env))
+}
+
+func isPerLaunchEnv(kv string) bool {
+	for _, name := range perLaunchEnv {
+		if strings.HasPrefix(kv, name+"=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secretEnvName matches the names of variables whose values are redacted
+// in the status API.
+var secretEnvName = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSW|CREDENTIAL|PRIVATE|AUTH|COOKIE|SESSION|SALT|(^|_)(KEY|PASS|DSN)(_|$)|_PWD(_|$))`)
+
+const redacted = "[REDACTED]"
+
+// Env returns the environment the app was started with, with values that
+// look like secrets redacted. It returns nil until the environment is known.
+func (a *App) Env() map[string]string {
+	a.lock.Lock()
+	env := a.env
+	a.lock.Unlock()
+
+	if env == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(env))
+
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		if i == -1 {
+			continue
+		}
+
+		result[kv[:i]] = redactEnv(kv[:i], kv[i+1:])
+	}
+
+	return result
+}
+
+// redactEnv hides value if name looks like it holds a secret, or the
+// password if value is a URL with one, such as a DATABASE_URL.
+func redactEnv(name, value string) string {
+	if value == "" {
+		return value
+	}
+
+	if secretEnvName.MatchString(name) {
+		return redacted
+	}
+
+	if strings.Contains(value, "://") {
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				user := url.User(u.User.Username()).String()
+				u.User = nil
+				return strings.Replace(u.String(), "://", "://"+user+":"+redacted+"@", 1)
+			}
+		}
+	}
+
+	return value
+}
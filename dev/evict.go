@@ -0,0 +1,64 @@
+package dev
+
+import (
+	"fmt"
+	"time"
+)
+
+// evictionCandidate returns the least recently used app that may be stopped
+// to make room for another one, or nil if none needs to be. It also returns
+// how many apps are running. It must be called with the pool locked.
+func (pool *AppPool) evictionCandidate() (*App, int) {
+	running := map[*App]bool{}
+	for _, app := range pool.apps {
+		if app.Command != nil {
+			running[app] = true
+		}
+	}
+
+	if pool.MaxRunning <= 0 || len(running) < pool.MaxRunning {
+		return nil, len(running)
+	}
+
+	var lru *App
+
+	for app := range running {
+		if pool.keptWarm(app) {
+			continue
+		}
+
+		if lru == nil || app.lastUse.Before(lru.lastUse) {
+			lru = app
+		}
+	}
+
+	return lru, len(running)
+}
+
+// makeRoom stops the least recently used app if launching another one would
+// go over MaxRunning. Keep warm apps are never stopped. It must be called
+// with the pool locked.
+func (pool *AppPool) makeRoom() {
+	lru, running := pool.evictionCandidate()
+	if lru == nil {
+		if pool.MaxRunning > 0 && running >= pool.MaxRunning {
+			fmt.Printf("! %d apps are running but all are kept warm, not stopping any\n", running)
+		}
+		return
+	}
+
+	for name, app := range pool.apps {
+		if app == lru {
+			delete(pool.apps, name)
+		}
+	}
+
+	lru.eventAdd("evicting_app",
+		"running", running,
+		"max_running", pool.MaxRunning,
+		"last_used", time.Since(lru.lastUse).String(),
+	)
+
+	// Kill removes the app from the pool, which needs the lock we're holding.
+	go lru.Kill("evicted to stay under max running apps")
+}
@@ -0,0 +1,108 @@
+package dev
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictionCandidate(t *testing.T) {
+	now := time.Now()
+
+	oldest := &App{Command: &exec.Cmd{}, Config: &AppConfig{}, lastUse: now.Add(-time.Hour)}
+	warm := &App{Command: &exec.Cmd{}, Config: &AppConfig{KeepWarm: true}, lastUse: now.Add(-2 * time.Hour)}
+	recent := &App{Command: &exec.Cmd{}, Config: &AppConfig{}, lastUse: now}
+	proxy := &App{lastUse: now.Add(-3 * time.Hour)}
+
+	pool := &AppPool{
+		MaxRunning: 3,
+		apps: map[string]*App{
+			"oldest":       oldest,
+			"oldest-alias": oldest,
+			"warm":         warm,
+			"recent":       recent,
+			"proxy":        proxy,
+		},
+	}
+
+	app, running := pool.evictionCandidate()
+	assert.Equal(t, oldest, app)
+	assert.Equal(t, 3, running)
+
+	pool.MaxRunning = 4
+	app, _ = pool.evictionCandidate()
+	assert.Nil(t, app)
+
+	pool.MaxRunning = 0
+	app, _ = pool.evictionCandidate()
+	assert.Nil(t, app)
+
+	pool.MaxRunning = 1
+	pool.apps = map[string]*App{"warm": warm}
+	app, running = pool.evictionCandidate()
+	assert.Nil(t, app)
+	assert.Equal(t, 1, running)
+}
+
+func TestMakeRoom(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	assert.NoError(t, err)
+
+	writeAppOrFail(t, filepath.Join(poolDir, "old"), "")
+	writeAppOrFail(t, filepath.Join(poolDir, "used"), "")
+	writeAppOrFail(t, filepath.Join(poolDir, "new"), "")
+
+	pool := &AppPool{
+		Dir:         poolDir,
+		IdleTime:    time.Hour,
+		BootTimeout: 20 * time.Second,
+		StopTimeout: time.Second,
+		MaxRunning:  2,
+		Events:      &Events{},
+	}
+	defer pool.Purge()
+
+	launch := func(name string) *App {
+		app, err := pool.lookupApp(name)
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+
+		if err := app.WaitTilReady(); err != nil {
+			assert.FailNow(t, err.Error(), app.Log())
+		}
+
+		return app
+	}
+
+	old := launch("old")
+	used := launch("used")
+	launch("new")
+
+	select {
+	case <-old.t.Dead():
+	case <-time.After(10 * time.Second):
+		assert.FailNow(t, "least recently used app was not stopped")
+	}
+
+	assert.Equal(t, Running, used.Status())
+
+	pool.lock.Lock()
+	_, oldRunning := pool.apps["old"]
+	running := len(pool.apps)
+	pool.lock.Unlock()
+
+	assert.False(t, oldRunning)
+	assert.Equal(t, 2, running)
+
+	var events bytes.Buffer
+	pool.Events.WriteTo(&events)
+	assert.Contains(t, events.String(), `"event":"evicting_app","app":"old","running":2,"max_running":2`)
+}
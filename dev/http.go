@@ -83,7 +83,9 @@ func (h *HTTPServer) Setup() {
 	h.mux = pat.New()
 
 	h.mux.Get("/status", http.HandlerFunc(h.status))
+	h.mux.Get("/status/:app/env", http.HandlerFunc(h.appEnv))
 	h.mux.Get("/events", http.HandlerFunc(h.events))
+	h.mux.Post("/reset/:app", http.HandlerFunc(h.reset))
 }
 
 func (h *HTTPServer) AppClosed(app *App) {
@@ -143,7 +145,29 @@ func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	name := h.removeTLD(req.Host)
 
+	// The retry button on a failure page only clears the app's backoff, the
+	// page then reloads to boot it. Other requests with the header, such as
+	// ones to an app that is running fine, are passed on as usual.
+	retry := req.Header.Get(RetryHeader) != ""
+
+	if retry && h.Pool.ResetCrashLoop(name) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	app, err := h.Pool.FindAppByDomainName(name)
+
+	if cle, ok := err.(*CrashLoopError); ok {
+		if retry {
+			h.Pool.ResetCrashLoop(cle.Name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.serveCrashLoop(w, req, cle)
+		return
+	}
+
 	if err != nil {
 		if err == ErrUnknownApp {
 			h.Events.Add("unknown_app", "name", name, "host", req.Host)
@@ -156,10 +180,19 @@ func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Counted so a blue/green restart can wait for this request before
+	// stopping the instance serving it.
+	app.beginRequest()
+	defer app.endRequest()
+
+	if app.Status() == Booting && wantsHTML(req) {
+		h.serveBooting(w, req, app)
+		return
+	}
+
 	err = app.WaitTilReady()
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(err.Error()))
+		h.serveBootFailure(w, req, app, err)
 		return
 	}
 
@@ -182,6 +215,11 @@ func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		req.Header.Set("X-Forwarded-Proto", "https")
 	}
 
+	if app.proxy != nil {
+		app.proxy.ServeHTTP(w, req)
+		return
+	}
+
 	req.URL.Scheme, req.URL.Host = app.Scheme, app.Address()
 	if app.Scheme == "httpu" {
 		req.URL.Scheme, req.URL.Host = "http", app.Address()
@@ -217,10 +255,16 @@ func (h *HTTPServer) shouldServePublicPathForApp(a *App, req *http.Request) bool
 
 func (h *HTTPServer) status(w http.ResponseWriter, req *http.Request) {
 	type appStatus struct {
-		Scheme  string `json:"scheme"`
-		Address string `json:"address"`
-		Status  string `json:"status"`
-		Log     string `json:"log"`
+		Scheme    string           `json:"scheme"`
+		Address   string           `json:"address"`
+		Status    string           `json:"status"`
+		Config    *AppConfig       `json:"config,omitempty"`
+		Usage     *AppUsage        `json:"usage,omitempty"`
+		Cgroup    *CgroupUsage     `json:"cgroup,omitempty"`
+		Sidecars  []SidecarStatus  `json:"sidecars,omitempty"`
+		Upstreams []UpstreamStatus `json:"upstreams,omitempty"`
+		Log       string           `json:"log"`
+		LogFile   string           `json:"log_file,omitempty"`
 	}
 
 	statuses := map[string]appStatus{}
@@ -239,17 +283,64 @@ func (h *HTTPServer) status(w http.ResponseWriter, req *http.Request) {
 			status = "unknown"
 		}
 
+		usage, _ := a.sampleUsage()
+
 		statuses[a.Name] = appStatus{
-			Scheme:  a.Scheme,
-			Address: a.Address(),
-			Status:  status,
-			Log:     a.Log(),
+			Scheme:    a.Scheme,
+			Address:   a.Address(),
+			Status:    status,
+			Config:    a.Config,
+			Usage:     usage,
+			Cgroup:    a.cgroup.usage(),
+			Sidecars:  a.sidecarStatuses(),
+			Upstreams: a.proxy.statuses(),
+			Log:       a.Log(),
+			LogFile:   a.logFile.Path(),
 		}
 	})
 
 	json.NewEncoder(w).Encode(statuses)
 }
 
+func (h *HTTPServer) appEnv(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get(":app")
+
+	var app *App
+
+	h.Pool.ForApps(func(a *App) {
+		if a.Name == name {
+			app = a
+		}
+	})
+
+	if app == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "app '%s' is not running\n", name)
+		return
+	}
+
+	env := app.Env()
+	if env == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "app '%s' has not finished booting\n", name)
+		return
+	}
+
+	json.NewEncoder(w).Encode(env)
+}
+
 func (h *HTTPServer) events(w http.ResponseWriter, req *http.Request) {
 	h.Events.WriteTo(w)
 }
+
+func (h *HTTPServer) reset(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get(":app")
+
+	if !h.Pool.ResetCrashLoop(name) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "app '%s' is not backing off\n", name)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
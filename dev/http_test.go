@@ -1,8 +1,13 @@
 package dev
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
+	. "github.com/puma/puma-dev/dev/devtest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -63,3 +68,45 @@ func TestHttp_removeTLD_multipartTLDSimilarToShorterOne(t *testing.T) {
 
 	assert.Equal(t, "confusing-riddle", str)
 }
+
+func TestHttp_retryHeader(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "proxied")
+	}))
+	defer upstream.Close()
+
+	poolDir := filepath.Join("tmp", "pool")
+	MakeDirectoryOrFail(t, filepath.Join(poolDir, "crashy", "tmp"))
+	writeFileOrFail(t, filepath.Join(poolDir, "healthy"), upstream.URL)
+
+	pool := &AppPool{Dir: poolDir, Events: &Events{}}
+	defer pool.Purge()
+
+	h := &HTTPServer{Pool: pool, Events: pool.Events}
+	h.Setup()
+
+	crashy := &App{Name: "crashy", dir: filepath.Join(poolDir, "crashy"), pool: pool, Events: pool.Events}
+	pool.recordCrash(crashy, &BootFailure{App: "crashy"})
+
+	retry := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://"+host+"/", nil)
+		req.Header.Set(RetryHeader, "1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := retry("crashy.test")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, pool.ResetCrashLoop("crashy"), "the backoff was cleared")
+
+	w = retry("healthy.test")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "proxied", w.Body.String())
+
+	w = retry("missing.test")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ErrUnknownApp.Error(), w.Body.String())
+}
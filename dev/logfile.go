@@ -0,0 +1,168 @@
+package dev
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLogMaxSize is how big an app's log file grows before it is
+	// rotated.
+	DefaultLogMaxSize = 10 << 20
+
+	// DefaultLogKeep is how many rotated log files are kept per app.
+	DefaultLogKeep = 5
+)
+
+// logFile is an app's log on disk. Lines are prefixed with a timestamp and
+// the pid of the process that wrote them. Once the file reaches maxSize it
+// is renamed to <path>.1, pushing older files up to <path>.<keep>.
+type logFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// logFile returns the log file for the named app, shared by every instance
+// of it, or nil if LogDir isn't set.
+func (pool *AppPool) logFile(name string) *logFile {
+	if pool.LogDir == "" {
+		return nil
+	}
+
+	pool.logLock.Lock()
+	defer pool.logLock.Unlock()
+
+	if pool.logs == nil {
+		pool.logs = make(map[string]*logFile)
+	}
+
+	lf, ok := pool.logs[name]
+	if !ok {
+		maxSize := int64(pool.LogMaxSize)
+		if maxSize <= 0 {
+			maxSize = DefaultLogMaxSize
+		}
+
+		lf = &logFile{
+			path:    filepath.Join(pool.LogDir, name+".log"),
+			maxSize: maxSize,
+			keep:    pool.LogKeep,
+		}
+
+		pool.logs[name] = lf
+	}
+
+	return lf
+}
+
+func (lf *logFile) open() error {
+	err := os.MkdirAll(filepath.Dir(lf.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(lf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	lf.f = f
+	lf.size = stat.Size()
+
+	return nil
+}
+
+// WriteLine appends line to the log, opening the file if needed.
+func (lf *logFile) WriteLine(pid int, line string) error {
+	if lf == nil {
+		return nil
+	}
+
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+
+	entry := fmt.Sprintf("%s [%d] %s", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), pid, line)
+
+	if lf.f != nil && lf.size > 0 && lf.size+int64(len(entry)) > lf.maxSize {
+		lf.rotate()
+	}
+
+	if lf.f == nil {
+		err := lf.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := lf.f.WriteString(entry)
+	lf.size += int64(n)
+
+	return err
+}
+
+// rotate must be called with lf.mu held. The file is reopened by the next
+// write.
+func (lf *logFile) rotate() {
+	lf.f.Close()
+	lf.f = nil
+
+	if lf.keep <= 0 {
+		os.Remove(lf.path)
+		return
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", lf.path, lf.keep))
+
+	for i := lf.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", lf.path, i), fmt.Sprintf("%s.%d", lf.path, i+1))
+	}
+
+	os.Rename(lf.path, lf.path+".1")
+}
+
+// Close closes the file until the next write.
+func (lf *logFile) Close() error {
+	if lf == nil {
+		return nil
+	}
+
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.f == nil {
+		return nil
+	}
+
+	err := lf.f.Close()
+	lf.f = nil
+
+	return err
+}
+
+// Path is where the log is written, or "" if apps aren't logged to files.
+func (lf *logFile) Path() string {
+	if lf == nil {
+		return ""
+	}
+
+	return lf.path
+}
@@ -0,0 +1,75 @@
+package dev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func readFileOrFail(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	return string(data)
+}
+
+func TestLogFile_writeLine(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	pool := &AppPool{LogDir: filepath.Join("tmp", "logs")}
+
+	lf := pool.logFile("app")
+	assert.Same(t, lf, pool.logFile("app"))
+	assert.Equal(t, filepath.Join("tmp", "logs", "app.log"), lf.Path())
+
+	assert.NoError(t, lf.WriteLine(1234, "Puma starting\n"))
+	assert.NoError(t, lf.WriteLine(1234, "no newline"))
+	assert.NoError(t, lf.Close())
+
+	// Writing after Close reopens the file and appends to it.
+	assert.NoError(t, lf.WriteLine(42, "after close\n"))
+	assert.NoError(t, lf.Close())
+
+	lines := strings.Split(strings.TrimSpace(readFileOrFail(t, lf.Path())), "\n")
+	assert.Len(t, lines, 3)
+	assert.Regexp(t, `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S+ \[1234\] Puma starting$`, lines[0])
+	assert.Regexp(t, ` \[1234\] no newline$`, lines[1])
+	assert.Regexp(t, ` \[42\] after close$`, lines[2])
+}
+
+func TestLogFile_rotate(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	pool := &AppPool{LogDir: filepath.Join("tmp", "logs"), LogMaxSize: 100, LogKeep: 2}
+
+	lf := pool.logFile("app")
+	defer lf.Close()
+
+	// Each entry is over 50 bytes, so a file only fits one of them.
+	for _, line := range []string{"one", "two", "three", "four", "five", "six", "seven"} {
+		assert.NoError(t, lf.WriteLine(1, line+strings.Repeat(".", 20)))
+	}
+
+	assert.Contains(t, readFileOrFail(t, lf.Path()), "seven")
+	assert.Contains(t, readFileOrFail(t, lf.Path()+".1"), "six")
+	assert.Contains(t, readFileOrFail(t, lf.Path()+".2"), "five")
+
+	_, err := os.Stat(lf.Path() + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLogFile_disabled(t *testing.T) {
+	pool := &AppPool{}
+
+	lf := pool.logFile("app")
+	assert.Nil(t, lf)
+	assert.NoError(t, lf.WriteLine(1, "dropped"))
+	assert.Equal(t, "", lf.Path())
+}
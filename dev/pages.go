@@ -0,0 +1,256 @@
+package dev
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pageStyle = `
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+th { text-align: left; vertical-align: top; padding-right: 1em; }
+pre { background: #1e1e1e; color: #ddd; padding: 1em; overflow-x: auto; font-size: 12px; line-height: 1.4; }
+.muted { color: #777; }
+`
+
+var bootingPageHead = template.Must(template.New("booting").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Booting {{.Name}}</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<h1>Booting {{.Name}}&hellip;</h1>
+<p class="muted">
+  Started <span id="elapsed">{{.Elapsed}}</span>s ago{{if .Timeout}}, giving up after {{.Timeout}}{{end}}.
+  This page reloads once the app is ready.
+</p>
+<script>
+  (function() {
+    var started = Date.now() - {{.Elapsed}} * 1000;
+    setInterval(function() {
+      document.getElementById("elapsed").textContent = Math.round((Date.now() - started) / 1000);
+    }, 1000);
+  })();
+</script>
+<pre>`))
+
+var bootingPageFoot = template.Must(template.New("booting-foot").Parse(`</pre>
+{{if .Ready}}<meta http-equiv="refresh" content="0">
+{{else}}{{template "failure" .Failure}}
+{{end}}</body>
+</html>
+`))
+
+var failureTemplate = `{{define "failure"}}<h1>{{.App}} failed to boot</h1>
+<p>{{.Error}}</p>
+<table>
+<tr><th>Directory</th><td><code>{{.Dir}}</code></td></tr>
+<tr><th>Command</th><td><pre>{{.Command}}</pre></td></tr>
+<tr><th>Exit code</th><td>{{.ExitCode}}</td></tr>
+</table>
+{{if .Failures}}<p>It has crashed {{.Failures}} times in a row, puma-dev will try again in {{.RetryIn}}.</p>
+{{end}}<h2>Last {{len .Log}} lines of output</h2>
+<pre>{{range .Log}}{{.}}{{end}}</pre>
+<p><button onclick="fetch(location.href, {method: 'POST', headers: {'` + RetryHeader + `': '1'}}).then(function() { location.reload() })">Retry boot</button></p>
+{{end}}`
+
+var failurePage = template.Must(template.New("failure-page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.App}} failed to boot</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+{{template "failure" .}}
+</body>
+</html>
+`))
+
+func init() {
+	template.Must(bootingPageFoot.Parse(failureTemplate))
+	template.Must(failurePage.Parse(failureTemplate))
+}
+
+// RetryHeader is sent by the retry button on the failure page to clear any
+// crash loop backoff before the page is reloaded.
+const RetryHeader = "X-Puma-Dev-Retry"
+
+type bootResult struct {
+	Ready   bool
+	Failure *BootFailure
+}
+
+// BootFailureLines is how many lines of app output are shown for a boot
+// failure.
+const BootFailureLines = 50
+
+// BootFailure describes an app that stopped before it finished booting.
+type BootFailure struct {
+	App      string   `json:"app"`
+	Dir      string   `json:"dir"`
+	Command  string   `json:"command"`
+	ExitCode int      `json:"exit_code"`
+	Error    string   `json:"error"`
+	Log      []string `json:"log"`
+
+	// Set when the failure is being served while the app is backing off
+	// from a crash loop.
+	Failures int    `json:"failures,omitempty"`
+	RetryIn  string `json:"retry_in,omitempty"`
+}
+
+// bootFailure waits briefly for the app to finish shutting down so its exit
+// code is known, then collects what went wrong.
+func (a *App) bootFailure(err error) *BootFailure {
+	select {
+	case <-a.t.Dead():
+	case <-time.After(5 * time.Second):
+	}
+
+	return a.failureDetails(err)
+}
+
+func (a *App) failureDetails(err error) *BootFailure {
+	dir, rerr := filepath.EvalSymlinks(a.dir)
+	if rerr != nil {
+		dir = a.dir
+	}
+
+	f := &BootFailure{
+		App:      a.Name,
+		Dir:      dir,
+		Command:  a.launchCommand,
+		ExitCode: a.exitCode,
+		Error:    err.Error(),
+		Log:      []string{},
+	}
+
+	a.lines.Do(func(line string) error {
+		if !strings.HasPrefix(line, "#event ") {
+			f.Log = append(f.Log, line)
+		}
+		return nil
+	})
+
+	if len(f.Log) > BootFailureLines {
+		f.Log = f.Log[len(f.Log)-BootFailureLines:]
+	}
+
+	return f
+}
+
+func (h *HTTPServer) serveBootFailure(w http.ResponseWriter, req *http.Request, app *App, err error) {
+	status := http.StatusInternalServerError
+	if err == ErrBootTimeout {
+		status = http.StatusGatewayTimeout
+	}
+
+	writeFailure(w, req, status, err, func() *BootFailure {
+		return app.bootFailure(err)
+	})
+}
+
+func (h *HTTPServer) serveCrashLoop(w http.ResponseWriter, req *http.Request, cle *CrashLoopError) {
+	retryIn := time.Until(cle.Until).Round(time.Second)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryIn.Seconds())))
+
+	writeFailure(w, req, http.StatusServiceUnavailable, cle, func() *BootFailure {
+		failure := *cle.Failure
+		failure.Failures = cle.Failures
+		failure.RetryIn = retryIn.String()
+		return &failure
+	})
+}
+
+func writeFailure(w http.ResponseWriter, req *http.Request, status int, err error, failure func() *BootFailure) {
+	accept := req.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, "application/json"):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(failure())
+	case wantsHTML(req):
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		failurePage.Execute(w, failure())
+	default:
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+// wantsHTML reports whether the request most likely comes from a browser
+// navigating to the app.
+func wantsHTML(req *http.Request) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// serveBooting streams an interstitial page with the app's log output until
+// the app is ready or has given up booting.
+func (h *HTTPServer) serveBooting(w http.ResponseWriter, req *http.Request, app *App) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	if req.Method == "HEAD" {
+		return
+	}
+
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	bootingPageHead.Execute(w, struct {
+		Name    string
+		Elapsed int
+		Timeout time.Duration
+	}{
+		Name:    app.Name,
+		Elapsed: int(time.Since(app.started).Seconds()),
+		Timeout: app.Config.BootTimeout.Duration,
+	})
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	var n int
+
+	for {
+		n, _ = app.lines.DoFrom(n, func(line string) error {
+			_, err := io.WriteString(w, template.HTMLEscapeString(line))
+			return err
+		})
+
+		flush()
+
+		select {
+		case <-app.readyChan:
+			bootingPageFoot.Execute(w, bootResult{Ready: true})
+			return
+		case <-app.t.Dying():
+			bootingPageFoot.Execute(w, bootResult{Failure: app.bootFailure(app.t.Err())})
+			return
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
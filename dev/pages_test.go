@@ -0,0 +1,148 @@
+package dev
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func deadTestApp() (*App, error) {
+	app := &App{
+		Name:          "crashy",
+		dir:           "/does/not/exist",
+		launchCommand: "bundle exec puma",
+		exitCode:      1,
+	}
+
+	app.lines.Append("#event {\"event\":\"booting_app\"}\n")
+	app.lines.Append("Bundler::GemNotFound: Could not find puma\n")
+
+	err := errors.New("unexpected exit")
+	app.t.Go(func() error { return err })
+	app.t.Wait()
+
+	return app, err
+}
+
+func TestHttp_serveBootFailure_json(t *testing.T) {
+	app, err := deadTestApp()
+
+	req := httptest.NewRequest("GET", "http://crashy.test/", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+
+	testHttp.serveBootFailure(w, req, app, err)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	var failure BootFailure
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &failure))
+	assert.Equal(t, BootFailure{
+		App:      "crashy",
+		Dir:      "/does/not/exist",
+		Command:  "bundle exec puma",
+		ExitCode: 1,
+		Error:    "unexpected exit",
+		Log:      []string{"Bundler::GemNotFound: Could not find puma\n"},
+	}, failure)
+}
+
+func TestHttp_serveBootFailure_html(t *testing.T) {
+	app, err := deadTestApp()
+
+	req := httptest.NewRequest("GET", "http://crashy.test/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := httptest.NewRecorder()
+
+	testHttp.serveBootFailure(w, req, app, err)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "<h1>crashy failed to boot</h1>")
+	assert.Contains(t, w.Body.String(), "Bundler::GemNotFound")
+	assert.NotContains(t, w.Body.String(), "booting_app")
+}
+
+func TestHttp_serveBootFailure_text(t *testing.T) {
+	app, _ := deadTestApp()
+
+	req := httptest.NewRequest("GET", "http://crashy.test/", nil)
+	w := httptest.NewRecorder()
+
+	testHttp.serveBootFailure(w, req, app, ErrBootTimeout)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "app did not boot in time", w.Body.String())
+}
+
+func TestHttp_serveBooting_bootTimeout(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	assert.NoError(t, err)
+
+	appDir := filepath.Join(poolDir, "slow")
+	MakeDirectoryOrFail(t, filepath.Join(appDir, "tmp"))
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `
+command = "echo still booting; exec sleep 30"
+boot_timeout = "2s"
+stop_timeout = "1s"
+`)
+
+	pool := &AppPool{
+		Dir:         poolDir,
+		IdleTime:    time.Hour,
+		StopTimeout: time.Second,
+		Events:      &Events{},
+	}
+
+	h := &HTTPServer{Pool: pool, Events: pool.Events}
+	h.Setup()
+
+	app, err := pool.lookupApp("slow")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer pool.Purge()
+
+	req := httptest.NewRequest("GET", "http://slow.test/", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+
+	// The booting page is streamed until the app gives up booting.
+	h.ServeHTTP(w, req)
+
+	assert.True(t, time.Since(start) >= 2*time.Second, "served before the boot timeout")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "<h1>Booting slow&hellip;</h1>")
+	assert.Contains(t, w.Body.String(), "giving up after 2s")
+	assert.Contains(t, w.Body.String(), "still booting")
+	assert.Contains(t, w.Body.String(), "<h1>slow failed to boot</h1>")
+	assert.Contains(t, w.Body.String(), ErrBootTimeout.Error())
+
+	select {
+	case <-app.t.Dead():
+	case <-time.After(10 * time.Second):
+		assert.FailNow(t, "app was not stopped after the boot timeout")
+	}
+
+	assert.Equal(t, ErrBootTimeout, app.t.Err())
+	assert.Equal(t, syscall.ESRCH, syscall.Kill(-app.Command.Process.Pid, 0), "app process group is gone")
+
+	var events bytes.Buffer
+	pool.Events.WriteTo(&events)
+	assert.Contains(t, events.String(), `"event":"boot_timeout","app":"slow","timeout":"2s"`)
+	assert.Contains(t, events.String(), `"event":"killing_app","app":"slow"`)
+}
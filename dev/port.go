@@ -0,0 +1,109 @@
+package dev
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ListenUnix has the app listen on a unix socket passed as $SOCKET.
+	ListenUnix = "unix"
+
+	// ListenTCP has the app listen on a loopback port passed as $PORT, for
+	// apps that can't bind unix sockets.
+	ListenTCP = "tcp"
+)
+
+// ParsePortRange parses a range like "9300-9399".
+func ParsePortRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range '%s', expected <first>-<last>", s)
+	}
+
+	first, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range '%s': %s", s, err)
+	}
+
+	last, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range '%s': %s", s, err)
+	}
+
+	if first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range '%s'", s)
+	}
+
+	return first, last, nil
+}
+
+// allocatePort finds a free loopback port for an app, from MinPort to
+// MaxPort if they are set or from the kernel otherwise. The range is walked
+// round robin so a port that was just released isn't handed out again
+// straight away.
+func (pool *AppPool) allocatePort() (int, error) {
+	pool.portLock.Lock()
+	defer pool.portLock.Unlock()
+
+	if pool.ports == nil {
+		pool.ports = make(map[int]bool)
+	}
+
+	if pool.MinPort == 0 {
+		for i := 0; i < 10; i++ {
+			port, err := listenPort(0)
+			if err != nil {
+				return 0, err
+			}
+
+			if !pool.ports[port] {
+				pool.ports[port] = true
+				return port, nil
+			}
+		}
+
+		return 0, fmt.Errorf("no free port available")
+	}
+
+	size := pool.MaxPort - pool.MinPort + 1
+
+	for i := 0; i < size; i++ {
+		port := pool.MinPort + (pool.nextPort+i)%size
+
+		if pool.ports[port] {
+			continue
+		}
+
+		if _, err := listenPort(port); err != nil {
+			continue
+		}
+
+		pool.nextPort = (port - pool.MinPort + 1) % size
+		pool.ports[port] = true
+
+		return port, nil
+	}
+
+	return 0, fmt.Errorf("no free port between %d and %d", pool.MinPort, pool.MaxPort)
+}
+
+func (pool *AppPool) releasePort(port int) {
+	pool.portLock.Lock()
+	defer pool.portLock.Unlock()
+
+	delete(pool.ports, port)
+}
+
+// listenPort checks that a loopback port is free by listening on it.
+func listenPort(port int) (int, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
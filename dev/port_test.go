@@ -0,0 +1,68 @@
+package dev
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRange(t *testing.T) {
+	first, last, err := ParsePortRange("9300-9399")
+	assert.NoError(t, err)
+	assert.Equal(t, 9300, first)
+	assert.Equal(t, 9399, last)
+
+	for _, bad := range []string{"9300", "a-b", "9399-9300", "0-10", "65000-70000"} {
+		_, _, err = ParsePortRange(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestAllocatePort_range(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer taken.Close()
+
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+
+	// The range starts at a port something else is listening on.
+	pool := &AppPool{MinPort: takenPort, MaxPort: takenPort + 2}
+
+	var ports []int
+	for i := 0; i < 2; i++ {
+		port, err := pool.allocatePort()
+		if err != nil {
+			// Something else may hold the neighbouring ports.
+			t.Skip(err)
+		}
+		ports = append(ports, port)
+	}
+
+	assert.Equal(t, []int{takenPort + 1, takenPort + 2}, ports)
+
+	_, err = pool.allocatePort()
+	assert.EqualError(t, err, fmt.Sprintf("no free port between %d and %d", takenPort, takenPort+2))
+
+	pool.releasePort(takenPort + 1)
+
+	port, err := pool.allocatePort()
+	assert.NoError(t, err)
+	assert.Equal(t, takenPort+1, port)
+}
+
+func TestAllocatePort_any(t *testing.T) {
+	pool := &AppPool{}
+
+	a, err := pool.allocatePort()
+	assert.NoError(t, err)
+
+	b, err := pool.allocatePort()
+	assert.NoError(t, err)
+
+	assert.NotZero(t, a)
+	assert.NotEqual(t, a, b)
+}
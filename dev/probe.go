@@ -0,0 +1,72 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const DefaultProbeInterval = 250 * time.Millisecond
+
+const probeTimeout = 5 * time.Second
+
+// ReadinessConfig configures how puma-dev decides an app has finished
+// booting. With no Path, the app is ready as soon as its socket accepts
+// connections.
+type ReadinessConfig struct {
+	Path     string   `toml:"path" json:"path,omitempty"`
+	Status   int      `toml:"status" json:"status,omitempty"`
+	Interval Duration `toml:"interval" json:"interval"`
+}
+
+func (a *App) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: probeTimeout}
+
+	if a.Scheme == "httpu" {
+		return dialer.DialContext(ctx, "unix", a.Address())
+	}
+
+	return dialer.DialContext(ctx, "tcp", a.Address())
+}
+
+// probe checks once whether the app is ready to serve requests.
+func (a *App) probe(rc ReadinessConfig) error {
+	c, err := a.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	c.Close()
+
+	if rc.Path == "" {
+		return nil
+	}
+
+	client := &http.Client{
+		Timeout: probeTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return a.dial(ctx)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+
+	resp, err := client.Get("http://localhost" + rc.Path)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != rc.Status {
+		return fmt.Errorf("readiness probe %s returned %d, expected %d",
+			rc.Path, resp.StatusCode, rc.Status)
+	}
+
+	return nil
+}
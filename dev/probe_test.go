@@ -0,0 +1,46 @@
+package dev
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_probe(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	socket := filepath.Join("tmp", "probe.sock")
+
+	app := &App{}
+	app.SetAddress("httpu", socket, 0)
+
+	rc := ReadinessConfig{Path: "/up", Status: http.StatusOK}
+
+	assert.Error(t, app.probe(rc), "nothing listening yet")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer l.Close()
+
+	var booted int32
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&booted) == 0 || req.URL.Path != "/up" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	assert.NoError(t, app.probe(ReadinessConfig{}), "socket accepts connections")
+	assert.EqualError(t, app.probe(rc), "readiness probe /up returned 503, expected 200")
+
+	atomic.StoreInt32(&booted, 1)
+
+	assert.NoError(t, app.probe(rc))
+}
@@ -0,0 +1,52 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ProcfileEntry is a single "name: command" line from a Procfile.
+type ProcfileEntry struct {
+	Name    string
+	Command string
+}
+
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// ParseProcfile reads the entries of a Procfile in the order they appear.
+// Blank lines and comments are skipped.
+func ParseProcfile(r io.Reader) ([]ProcfileEntry, error) {
+	var entries []ProcfileEntry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m := procfileLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("invalid Procfile line: %s", line)
+		}
+
+		entries = append(entries, ProcfileEntry{Name: m[1], Command: m[2]})
+	}
+
+	return entries, scanner.Err()
+}
+
+// ReadProcfile parses the Procfile at path.
+func ReadProcfile(path string) ([]ProcfileEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseProcfile(f)
+}
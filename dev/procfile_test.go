@@ -0,0 +1,29 @@
+package dev
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcfile(t *testing.T) {
+	entries, err := ParseProcfile(strings.NewReader(`
+# the app
+web: node server.js --socket $SOCKET
+
+worker:   bundle exec sidekiq
+`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ProcfileEntry{
+		{Name: "web", Command: "node server.js --socket $SOCKET"},
+		{Name: "worker", Command: "bundle exec sidekiq"},
+	}, entries)
+}
+
+func TestParseProcfile_invalidLine(t *testing.T) {
+	_, err := ParseProcfile(strings.NewReader("web node server.js"))
+
+	assert.EqualError(t, err, "invalid Procfile line: web node server.js")
+}
@@ -0,0 +1,200 @@
+package dev
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Host header modes of a ProxyConfig. Any other value is sent as the Host
+// header as is.
+const (
+	HostPreserve = "preserve"
+	HostRewrite  = "rewrite"
+)
+
+// ProxyConfig is a proxy file in the pool directory. Besides a plain port
+// or URL, a proxy file may hold this as TOML or JSON.
+type ProxyConfig struct {
+	URL string `toml:"url" json:"url"`
+
+	// Upstreams lists several URLs to spread requests across instead of
+	// URL, using the Balance strategy. An upstream that fails MaxFails
+	// times within FailTimeout is left out for FailTimeout. MaxFails of 0
+	// keeps failing upstreams in rotation.
+	Upstreams   []string `toml:"upstreams" json:"upstreams"`
+	Balance     string   `toml:"balance" json:"balance"`
+	MaxFails    int      `toml:"max_fails" json:"max_fails"`
+	FailTimeout Duration `toml:"fail_timeout" json:"fail_timeout"`
+
+	// HostHeader is HostPreserve to pass on the Host the request was made
+	// to, HostRewrite to use the host of URL, or the Host to send.
+	HostHeader string `toml:"host_header" json:"host_header"`
+
+	// Headers are set on requests and responses. An empty value removes
+	// the header.
+	RequestHeaders  map[string]string `toml:"request_headers" json:"request_headers"`
+	ResponseHeaders map[string]string `toml:"response_headers" json:"response_headers"`
+
+	DialTimeout     Duration `toml:"dial_timeout" json:"dial_timeout"`
+	ResponseTimeout Duration `toml:"response_timeout" json:"response_timeout"`
+
+	// InsecureSkipVerify accepts any certificate from an https URL, such
+	// as a self-signed one.
+	InsecureSkipVerify bool `toml:"insecure_skip_verify" json:"insecure_skip_verify"`
+
+	// structured is set when the file was TOML or JSON rather than a plain
+	// port or URL, which are proxied by the shared transports.
+	structured bool
+}
+
+// tomlProxyLine matches the start of a TOML proxy file, so a plain URL
+// with = in its query isn't mistaken for one.
+var tomlProxyLine = regexp.MustCompile(`^(\[|[A-Za-z_][A-Za-z0-9_-]*\s*=)`)
+
+// ParseProxyFile reads a proxy file. It can be a port on localhost, a
+// host:port, a URL, the path of a unix socket, or a ProxyConfig as JSON or
+// TOML. Unix sockets can be given as unix:///path/to.sock or
+// httpu:///path/to.sock too.
+func ParseProxyFile(data []byte) (*ProxyConfig, error) {
+	data = bytes.TrimSpace(data)
+
+	cfg := &ProxyConfig{
+		HostHeader:  HostPreserve,
+		Balance:     BalanceRoundRobin,
+		MaxFails:    DefaultMaxFails,
+		FailTimeout: Duration{DefaultFailTimeout},
+	}
+
+	switch {
+	case len(data) > 0 && data[0] == '{':
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, err
+		}
+
+		cfg.structured = true
+	case isTOMLProxy(data):
+		if _, err := toml.Decode(string(data), cfg); err != nil {
+			return nil, err
+		}
+
+		cfg.structured = true
+	default:
+		cfg.URL = string(data)
+	}
+
+	switch {
+	case cfg.URL != "" && len(cfg.Upstreams) > 0:
+		return nil, fmt.Errorf("set either url or upstreams, not both")
+	case cfg.URL != "":
+		cfg.URL = proxyURL(cfg.URL)
+		cfg.Upstreams = []string{cfg.URL}
+	case len(cfg.Upstreams) == 0:
+		return nil, fmt.Errorf("no url or upstreams set")
+	}
+
+	for i, upstream := range cfg.Upstreams {
+		cfg.Upstreams[i] = proxyURL(upstream)
+	}
+
+	if cfg.HostHeader == "" {
+		cfg.HostHeader = HostPreserve
+	}
+
+	switch cfg.Balance {
+	case BalanceRoundRobin, BalanceLeastConn:
+	default:
+		return nil, fmt.Errorf("unknown balance '%s', expected %s or %s", cfg.Balance, BalanceRoundRobin, BalanceLeastConn)
+	}
+
+	return cfg, nil
+}
+
+// proxyURL turns a port, host:port or socket path into a URL. URLs are
+// returned as is.
+func proxyURL(text string) string {
+	text = strings.TrimSpace(text)
+
+	if _, err := strconv.Atoi(text); err == nil {
+		text = "127.0.0.1:" + text
+	}
+
+	switch {
+	case strings.HasPrefix(text, "/"):
+		return "unix://" + text
+	case strings.HasPrefix(text, "unix:"), strings.HasPrefix(text, "httpu:"):
+		return text
+	case !strings.Contains(text, "://"):
+		return "http://" + text
+	}
+
+	return text
+}
+
+func isTOMLProxy(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		return tomlProxyLine.MatchString(line)
+	}
+
+	return false
+}
+
+// target returns the scheme, host and port of the first upstream.
+func (cfg *ProxyConfig) target() (scheme, host string, port int, err error) {
+	return proxyTarget(cfg.Upstreams[0])
+}
+
+// proxyTarget returns the scheme, host and port to proxy to for rawURL.
+// port is 0 when the URL doesn't have one, so the scheme's default is
+// used. Unix sockets get the httpu scheme with the socket path as the
+// host, like apps.
+func proxyTarget(rawURL string) (scheme, host string, port int, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	if u.Scheme == "unix" || u.Scheme == "httpu" {
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+
+		if u.Host != "" || !filepath.IsAbs(path) {
+			return "", "", 0, fmt.Errorf("socket path in %s must be absolute, like unix:///path/to.sock", rawURL)
+		}
+
+		return "httpu", path, 0, nil
+	}
+
+	host, sport, err := net.SplitHostPort(u.Host)
+	if err == nil {
+		port, err = strconv.Atoi(sport)
+		if err != nil {
+			return "", "", 0, err
+		}
+	} else {
+		host = u.Host
+	}
+
+	if host == "" {
+		return "", "", 0, fmt.Errorf("no host in url %s", rawURL)
+	}
+
+	return u.Scheme, host, port, nil
+}
@@ -0,0 +1,216 @@
+package dev
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProxyFile_plain(t *testing.T) {
+	for data, expected := range map[string]string{
+		"3000\n":                    "http://127.0.0.1:3000",
+		"10.3.1.2:9292":             "http://10.3.1.2:9292",
+		"https://api.example.com":   "https://api.example.com",
+		"http://localhost/?a=b&c=d": "http://localhost/?a=b&c=d",
+	} {
+		cfg, err := ParseProxyFile([]byte(data))
+		assert.NoError(t, err, data)
+		assert.Equal(t, expected, cfg.URL, data)
+		assert.Equal(t, HostPreserve, cfg.HostHeader, data)
+		assert.False(t, cfg.structured, data)
+	}
+}
+
+func TestParseProxyFile_structured(t *testing.T) {
+	cfg, err := ParseProxyFile([]byte(`
+# the api
+url = "https://localhost:8443"
+host_header = "rewrite"
+response_timeout = "30s"
+insecure_skip_verify = true
+
+[request_headers]
+X-Api-Key = "dev"
+`))
+
+	assert.NoError(t, err)
+	assert.True(t, cfg.structured)
+	assert.Equal(t, "https://localhost:8443", cfg.URL)
+	assert.Equal(t, HostRewrite, cfg.HostHeader)
+	assert.Equal(t, 30*time.Second, cfg.ResponseTimeout.Duration)
+	assert.True(t, cfg.InsecureSkipVerify)
+	assert.Equal(t, map[string]string{"X-Api-Key": "dev"}, cfg.RequestHeaders)
+
+	cfg, err = ParseProxyFile([]byte(`{"url": "http://localhost:4000", "dial_timeout": "1s", "response_headers": {"X-Frame-Options": ""}}`))
+
+	assert.NoError(t, err)
+	assert.True(t, cfg.structured)
+	assert.Equal(t, "http://localhost:4000", cfg.URL)
+	assert.Equal(t, HostPreserve, cfg.HostHeader)
+	assert.Equal(t, time.Second, cfg.DialTimeout.Duration)
+	assert.Equal(t, map[string]string{"X-Frame-Options": ""}, cfg.ResponseHeaders)
+
+	_, err = ParseProxyFile([]byte(`host_header = "rewrite"`))
+	assert.EqualError(t, err, "no url or upstreams set")
+
+	_, err = ParseProxyFile([]byte(`{"url": 3000}`))
+	assert.Error(t, err)
+}
+
+// proxyTo serves a proxy for cfg. Call the returned func to stop it.
+func proxyTo(t *testing.T, cfg *ProxyConfig) (*httptest.Server, func()) {
+	proxyApp := &App{Name: "proxy", Events: &Events{}}
+
+	if cfg.URL != "" {
+		cfg.Upstreams = []string{cfg.URL}
+	}
+
+	if cfg.Balance == "" {
+		cfg.Balance = BalanceRoundRobin
+	}
+
+	var err error
+	proxyApp.proxy, err = cfg.balancer(proxyApp)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	server := httptest.NewServer(proxyApp.proxy)
+
+	return server, func() {
+		server.Close()
+		proxyApp.proxy.close()
+	}
+}
+
+func TestProxyConfig_reverseProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Frame-Options", "DENY")
+		fmt.Fprintf(w, "%s %s", req.Host, req.Header.Get("X-Api-Key"))
+	}))
+	defer upstream.Close()
+
+	for mode, expected := range map[string]string{
+		HostPreserve:       "myapi.test dev",
+		HostRewrite:        upstream.Listener.Addr().String() + " dev",
+		"api.internal:443": "api.internal:443 dev",
+	} {
+		server, stop := proxyTo(t, &ProxyConfig{
+			URL:             upstream.URL,
+			HostHeader:      mode,
+			RequestHeaders:  map[string]string{"X-Api-Key": "dev"},
+			ResponseHeaders: map[string]string{"X-Frame-Options": "", "X-Proxied": "1"},
+		})
+		defer stop()
+
+		req, err := http.NewRequest("GET", server.URL, nil)
+		assert.NoError(t, err)
+		req.Host = "myapi.test"
+
+		res, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Equal(t, expected, string(body), mode)
+		assert.Equal(t, "", res.Header.Get("X-Frame-Options"))
+		assert.Equal(t, "1", res.Header.Get("X-Proxied"))
+	}
+}
+
+func TestProxyConfig_insecureSkipVerify(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer upstream.Close()
+
+	server, stop := proxyTo(t, &ProxyConfig{URL: upstream.URL, HostHeader: HostPreserve})
+	defer stop()
+
+	res, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	res.Body.Close()
+
+	server, stop = proxyTo(t, &ProxyConfig{URL: upstream.URL, HostHeader: HostPreserve, InsecureSkipVerify: true})
+	defer stop()
+
+	res, err = http.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+}
+
+func TestProxyConfig_unixSocket(t *testing.T) {
+	for data, expected := range map[string]string{
+		"unix:///tmp/app.sock":         "/tmp/app.sock",
+		"httpu:///tmp/app.sock":        "/tmp/app.sock",
+		"unix:/tmp/app.sock":           "/tmp/app.sock",
+		"/tmp/app.sock":                "/tmp/app.sock",
+		`url = "unix:///tmp/app.sock"`: "/tmp/app.sock",
+	} {
+		cfg, err := ParseProxyFile([]byte(data))
+		if !assert.NoError(t, err, data) {
+			continue
+		}
+
+		scheme, host, port, err := cfg.target()
+		assert.NoError(t, err, data)
+		assert.Equal(t, "httpu", scheme, data)
+		assert.Equal(t, expected, host, data)
+		assert.Equal(t, 0, port, data)
+	}
+
+	cfg, err := ParseProxyFile([]byte("unix://tmp/app.sock"))
+	assert.NoError(t, err)
+
+	_, _, _, err = cfg.target()
+	assert.Error(t, err)
+}
+
+func TestProxyConfig_reverseProxyUnixSocket(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	socket, err := filepath.Abs(filepath.Join("tmp", "proxy.sock"))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	upstream := &httptest.Server{
+		Listener: l,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, "%s %s", req.Host, req.URL.Path)
+		})},
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	server, stop := proxyTo(t, &ProxyConfig{URL: "unix://" + socket, HostHeader: HostRewrite})
+	defer stop()
+
+	res, err := http.Get(server.URL + "/hello")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, "localhost /hello", string(body))
+}
@@ -0,0 +1,346 @@
+package dev
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// RestartKill stops the app and boots it again on the next request.
+	RestartKill = "kill"
+
+	// RestartBlueGreen boots a new instance of the app and keeps serving
+	// requests from the old one until the new one is ready.
+	RestartBlueGreen = "blue-green"
+
+	// RestartPhased sends puma SIGUSR1 to restart its workers one at a
+	// time. It needs workers and no preload_app!, so other apps get a hot
+	// restart.
+	RestartPhased = "phased"
+
+	// RestartHot sends puma SIGUSR2 to reload itself in place.
+	RestartHot = "hot"
+)
+
+var (
+	// pumaBooted matches the line puma prints once it is booted, including
+	// after re-executing itself for a hot restart.
+	pumaBooted = regexp.MustCompile(`Use Ctrl-C to stop`)
+
+	// pumaWorkerBooted matches the line puma prints for each worker it
+	// boots, such as "- Worker 0 (PID: 1234) booted in 0.52s, phase: 1".
+	pumaWorkerBooted = regexp.MustCompile(`Worker (\d+) \((?i:pid): \d+\) booted`)
+
+	// pumaMode, pumaWorkers and pumaPreload match what puma prints about
+	// how it runs while booting, such as "Puma starting in cluster mode...",
+	// "*      Workers: 2" or "* Process workers: 2", and "* Preloading
+	// application".
+	pumaMode    = regexp.MustCompile(`Puma starting in (single|cluster) mode`)
+	pumaWorkers = regexp.MustCompile(`(?i)workers: (\d+)`)
+	pumaPreload = regexp.MustCompile(`Preloading application`)
+
+	// pumaPhasedRefused matches the line puma prints when it gets SIGUSR1
+	// but can't do a phased restart, and restarts like for SIGUSR2 instead.
+	pumaPhasedRefused = regexp.MustCompile(`phased-restart called but not available`)
+)
+
+// pumaBoot is what puma said about how it runs while booting. It decides
+// whether a phased restart is possible.
+type pumaBoot struct {
+	known   bool
+	workers int
+	preload bool
+}
+
+func (b *pumaBoot) scan(line string) {
+	if m := pumaMode.FindStringSubmatch(line); m != nil {
+		*b = pumaBoot{known: true}
+		return
+	}
+
+	if m := pumaWorkers.FindStringSubmatch(line); m != nil {
+		b.workers, _ = strconv.Atoi(m[1])
+	} else if pumaPreload.MatchString(line) {
+		b.preload = true
+	}
+}
+
+func (a *App) beginRequest() {
+	atomic.AddInt64(&a.inflight, 1)
+}
+
+func (a *App) endRequest() {
+	atomic.AddInt64(&a.inflight, -1)
+}
+
+// drain waits for requests already sent to the app to finish, for up to
+// timeout.
+func (a *App) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	for atomic.LoadInt64(&a.inflight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	a.eventAdd("drained_app", "inflight", atomic.LoadInt64(&a.inflight))
+}
+
+// replaceApp does a blue/green restart of old. A new instance is booted on
+// its own socket while old keeps serving requests. Once the new instance is
+// ready it takes old's place in the pool, and old is stopped after its
+// requests finish. If the new instance fails to boot, old keeps running.
+func (pool *AppPool) replaceApp(old *App, reason, trigger string) {
+	old.lock.Lock()
+	busy := old.restarting || old.stopping
+	old.restarting = true
+	old.lock.Unlock()
+
+	if busy {
+		return
+	}
+
+	failed := func(err error) {
+		old.eventAdd("blue_green_failed", "error", err.Error())
+		fmt.Printf("! New instance of '%s' failed to boot, keeping the old one running: %s\n", old.Name, err)
+
+		old.lock.Lock()
+		old.restarting = false
+		old.lock.Unlock()
+	}
+
+	instance := fmt.Sprintf("-%d", atomic.AddUint64(&pool.instances, 1))
+
+	old.eventAdd("blue_green_restart", "reason", reason, "trigger", trigger, "instance", instance)
+	fmt.Printf("! Restarting '%s' - '%s', booting a new instance\n", old.Name, reason)
+
+	app, err := pool.launchApp(old.Name, old.dir, old.profile, instance)
+	if err != nil {
+		failed(err)
+		return
+	}
+
+	select {
+	case <-app.readyChan:
+	case <-app.t.Dying():
+		failed(app.t.Err())
+		return
+	}
+
+	pool.lock.Lock()
+
+	swapped := false
+	for name, candidate := range pool.apps {
+		if candidate == old {
+			pool.apps[name] = app
+			swapped = true
+		}
+	}
+
+	// old stopped while the new instance was booting.
+	if _, ok := pool.apps[old.Name]; !ok && !swapped {
+		pool.apps[old.Name] = app
+		swapped = true
+	}
+
+	pool.lock.Unlock()
+
+	if !swapped {
+		// Another instance was booted in the meantime.
+		app.Kill("replaced app is gone")
+		return
+	}
+
+	app.eventAdd("blue_green_swapped",
+		"old_pid", old.Command.Process.Pid,
+		"pid", app.Command.Process.Pid,
+	)
+
+	old.drain(old.Config.StopTimeout.Duration)
+	old.Kill("replaced by new instance")
+
+	// Sidecars often listen on fixed ports, so the new instance's only
+	// start once the old ones are gone.
+	old.stopSidecars()
+	app.startPendingSidecars()
+}
+
+// signalRestart asks puma to restart itself with a phased or hot restart,
+// waits for puma to print that every worker or puma itself has booted again,
+// then probes the app until it is healthy. Puma is the app's process
+// since every shell in between execs it, and only puma itself should get the
+// signal: its workers would exit on SIGUSR1.
+func (a *App) signalRestart(strategy, reason, trigger string) {
+	a.lock.Lock()
+	busy := a.restarting || a.stopping
+	a.restarting = true
+	a.lock.Unlock()
+
+	if busy {
+		return
+	}
+
+	defer func() {
+		a.lock.Lock()
+		a.restarting = false
+		a.lock.Unlock()
+	}()
+
+	// What puma printed while booting beats the config, which doesn't know
+	// about workers set in puma's own config file.
+	a.lock.Lock()
+	boot := a.pumaBoot
+	a.lock.Unlock()
+
+	workers := a.Config.Workers
+	if boot.known {
+		workers = boot.workers
+	}
+
+	phased := false
+	if strategy == RestartPhased {
+		switch {
+		case workers == 0:
+			a.phasedUnavailable("puma is running in single mode")
+		case boot.preload:
+			a.phasedUnavailable("puma preloads the app")
+		default:
+			phased = true
+		}
+	}
+
+	sig, event := syscall.SIGUSR2, "hot_restart"
+	if phased {
+		sig, event = syscall.SIGUSR1, "phased_restart"
+	}
+
+	pid := a.Command.Process.Pid
+
+	// Watch the output for puma saying it is done. Until then the socket
+	// stays bound and old workers keep answering, so probing the app
+	// doesn't tell whether the restart finished.
+	output := make(chan string, 100)
+
+	a.lock.Lock()
+	a.restartOutput = output
+	a.lock.Unlock()
+
+	defer func() {
+		a.lock.Lock()
+		a.restartOutput = nil
+		a.lock.Unlock()
+	}()
+
+	a.eventAdd(event, "pid", pid, "reason", reason, "trigger", trigger)
+	fmt.Printf("! Restarting '%s' with a %s - '%s'\n", a.Name, event, reason)
+
+	err := a.Command.Process.Signal(sig)
+	if err != nil {
+		a.eventAdd("restart_error", "pid", pid, "error", err.Error())
+		a.kill(reason, "trigger", trigger)
+		return
+	}
+
+	start := time.Now()
+
+	var timeout <-chan time.Time
+	if a.Config.BootTimeout.Duration > 0 {
+		timer := time.NewTimer(a.Config.BootTimeout.Duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	booted := map[string]bool{}
+
+	unhealthy := func(args ...interface{}) {
+		a.eventAdd("restart_unhealthy", append([]interface{}{
+			"timeout", a.Config.BootTimeout.String(),
+		}, args...)...)
+		fmt.Printf("! App '%s' did not finish its %s within %s\n", a.Name, event, a.Config.BootTimeout)
+		a.Kill("not healthy after " + event)
+	}
+
+	for done := false; !done; {
+		select {
+		case <-a.t.Dying():
+			return
+		case <-timeout:
+			if phased {
+				unhealthy("waiting_for", "workers", "workers_booted", len(booted), "workers", workers)
+			} else {
+				unhealthy("waiting_for", "puma")
+			}
+			return
+		case line := <-output:
+			if !phased {
+				done = pumaBooted.MatchString(line)
+				continue
+			}
+
+			if pumaPhasedRefused.MatchString(line) {
+				a.phasedUnavailable("puma refused it")
+				phased, event = false, "hot_restart"
+				continue
+			}
+
+			if m := pumaWorkerBooted.FindStringSubmatch(line); m != nil {
+				booted[m[1]] = true
+			}
+
+			done = len(booted) >= workers
+		}
+	}
+
+	a.eventAdd("restart_finished", "restart", event, "took", time.Since(start).String())
+
+	ticker := time.NewTicker(a.Config.Readiness.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.t.Dying():
+			return
+		case <-timeout:
+			unhealthy("waiting_for", "readiness")
+			return
+		case <-ticker.C:
+			if a.probe(a.Config.Readiness) == nil {
+				took := time.Since(start)
+				a.eventAdd("restart_ready", "restart", event, "took", took.String())
+				fmt.Printf("! App '%s' is healthy again after %s\n", a.Name, took)
+				return
+			}
+		}
+	}
+}
+
+// phasedUnavailable records that a phased restart falls back to a hot
+// restart, and why.
+func (a *App) phasedUnavailable(reason string) {
+	a.eventAdd("phased_restart_unavailable", "reason", reason)
+	fmt.Printf("! Phased restart of '%s' is not available, %s, using a hot restart\n", a.Name, reason)
+}
+
+// restartLine keeps track of how puma runs for apps that may get a phased
+// restart, and passes a line of the app's output on to a phased or hot
+// restart in progress. Lines are dropped rather than holding up the output.
+func (a *App) restartLine(line string) {
+	a.lock.Lock()
+	if a.Config.RestartStrategy == RestartPhased {
+		a.pumaBoot.scan(line)
+	}
+	output := a.restartOutput
+	a.lock.Unlock()
+
+	if output == nil {
+		return
+	}
+
+	select {
+	case output <- line:
+	default:
+	}
+}
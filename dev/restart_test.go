@@ -0,0 +1,180 @@
+package dev
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrain(t *testing.T) {
+	app := &App{Events: &Events{}}
+
+	app.beginRequest()
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		app.endRequest()
+	}()
+
+	start := time.Now()
+	app.drain(5 * time.Second)
+
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, int64(0), app.inflight)
+}
+
+func TestDrain_timeout(t *testing.T) {
+	app := &App{Events: &Events{}}
+
+	app.beginRequest()
+
+	start := time.Now()
+	app.drain(200 * time.Millisecond)
+
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.Equal(t, int64(1), app.inflight)
+}
+
+func TestSignalRestart(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	socket := filepath.Join("tmp", "signal-restart.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer l.Close()
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	// The test process stands in for puma so it can see the signal.
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+
+	hotOutput := []string{
+		"* Restarting...\n",
+		"* Listening on unix://tmp/puma.sock\n",
+		"Use Ctrl-C to stop\n",
+	}
+
+	phasedOutput := []string{
+		"[123] - Starting phased worker restart, phase: 1\n",
+		"[123] - Worker 0 (PID: 456) booted in 0.52s, phase: 1\n",
+		"[123] - Worker 1 (PID: 457) booted in 0.48s, phase: 1\n",
+	}
+
+	clusterBoot := []string{
+		"[123] Puma starting in cluster mode...\n",
+		"[123] * Puma version: 6.4.0 (ruby 3.2.2-p53) (\"The Eagle of Durango\")\n",
+		"[123] *      Workers: 2\n",
+		"[123] *     Restarts: (\u2714) hot (\u2714) phased\n",
+		"[123] - Worker 0 (PID: 124) booted in 0.01s, phase: 0\n",
+	}
+
+	for _, tc := range []struct {
+		name        string
+		workers     int
+		boot        []string
+		signal      os.Signal
+		event       string
+		unavailable string
+		output      []string
+	}{
+		{"single", 0, nil, syscall.SIGUSR2, "hot_restart", "single mode", hotOutput},
+		{"workers", 2, nil, syscall.SIGUSR1, "phased_restart", "", phasedOutput},
+		{"puma config workers", 0, clusterBoot, syscall.SIGUSR1, "phased_restart", "", phasedOutput},
+		{"single from output", 2, []string{
+			"Puma starting in single mode...\n",
+		}, syscall.SIGUSR2, "hot_restart", "single mode", hotOutput},
+		{"preload", 2, append(clusterBoot, "[123] * Preloading application\n"),
+			syscall.SIGUSR2, "hot_restart", "preloads the app", hotOutput},
+		{"refused", 2, nil, syscall.SIGUSR1, "phased_restart", "refused", append([]string{
+			"[123] * phased-restart called but not available, restarting normally.\n",
+		}, hotOutput...)},
+	} {
+		var events Events
+
+		app := &App{
+			Name:    "app",
+			Events:  &events,
+			Command: &exec.Cmd{Process: self},
+			Config: &AppConfig{
+				Workers:         tc.workers,
+				RestartStrategy: RestartPhased,
+				Readiness:       ReadinessConfig{Interval: Duration{10 * time.Millisecond}},
+			},
+		}
+		app.SetAddress("httpu", socket, 0)
+
+		for _, line := range tc.boot {
+			app.restartLine(line)
+		}
+
+		done := make(chan struct{})
+
+		go func() {
+			app.signalRestart(RestartPhased, "test", "tmp/restart.txt")
+			close(done)
+		}()
+
+		select {
+		case sig := <-sigs:
+			assert.Equal(t, tc.signal, sig, tc.name)
+		case <-time.After(time.Second):
+			assert.Fail(t, "no signal received", tc.name)
+		}
+
+		// The socket answers throughout, but the restart isn't done until
+		// puma says so.
+		for _, line := range tc.output[:len(tc.output)-1] {
+			app.restartLine(line)
+		}
+
+		select {
+		case <-done:
+			assert.Fail(t, "restart finished before puma booted again", tc.name)
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		app.restartLine(tc.output[len(tc.output)-1])
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.Fail(t, "restart didn't finish", tc.name)
+		}
+
+		var buf bytes.Buffer
+		events.WriteTo(&buf)
+
+		assert.Contains(t, buf.String(), `"event":"`+tc.event+`"`, tc.name)
+		assert.Contains(t, buf.String(), `"event":"restart_finished"`, tc.name)
+		assert.Contains(t, buf.String(), `"event":"restart_ready"`, tc.name)
+		assert.False(t, app.restarting, tc.name)
+		assert.Nil(t, app.restartOutput, tc.name)
+
+		if tc.unavailable != "" {
+			assert.Regexp(t, `"event":"phased_restart_unavailable","app":"app","reason":"[^"]*`+tc.unavailable, buf.String(), tc.name)
+		} else {
+			assert.NotContains(t, buf.String(), "phased_restart_unavailable", tc.name)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vektra/errors"
+)
+
+// SidecarEntries returns the extra processes to run alongside the app: every
+// entry but web from the procfile_sidecars Procfile, then the sidecars table.
+func (cfg *AppConfig) SidecarEntries(dir string) ([]ProcfileEntry, error) {
+	var entries []ProcfileEntry
+
+	if cfg.ProcfileSidecars != "" {
+		path := cfg.ProcfileSidecars
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		procfile, err := ReadProcfile(path)
+		if err != nil {
+			return nil, errors.Context(err, "reading "+path)
+		}
+
+		for _, entry := range procfile {
+			if entry.Name != "web" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	var names []string
+	for name := range cfg.Sidecars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entries = append(entries, ProcfileEntry{Name: name, Command: cfg.Sidecars[name]})
+	}
+
+	return entries, nil
+}
+
+type sidecar struct {
+	name    string
+	command string
+	cmd     *exec.Cmd
+	done    chan struct{}
+}
+
+// SidecarStatus is how a sidecar is shown in /status.
+type SidecarStatus struct {
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	Pid      int    `json:"pid"`
+	Running  bool   `json:"running"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// startSidecars launches the app's sidecars with the same shell setup and
+// environment as the app, or straight from env if it is the cached one.
+// Each one runs in its own session and process group like the app, and its
+// output goes to the app log prefixed with its name.
+func (a *App) startSidecars(shell string, envCached bool, entries []ProcfileEntry, env []string) {
+	for _, entry := range entries {
+		var cmd *exec.Cmd
+		if envCached {
+			cmd = exec.Command("bash", "-c", customCommand)
+		} else {
+			cmd = exec.Command(shell, "-l", "-i", "-c",
+				fmt.Sprintf(executionShell, a.dir, customCommand))
+		}
+
+		cmd.Dir = a.dir
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		cmd.Env = append(append([]string{}, env...), "PUMADEV_COMMAND="+entry.Command)
+
+		a.cgroup.wrap(cmd)
+
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			cmd.Stderr = cmd.Stdout
+			err = cmd.Start()
+		}
+
+		if err != nil {
+			a.eventAdd("sidecar_error", "sidecar", entry.Name, "error", err.Error())
+			fmt.Printf("! Unable to start sidecar '%s' for '%s': %s\n", entry.Name, a.Name, err)
+			continue
+		}
+
+		if err := a.cgroup.join(cmd.Process.Pid); err != nil {
+			a.eventAdd("cgroup_error", "sidecar", entry.Name, "error", err.Error())
+		}
+
+		sc := &sidecar{
+			name:    entry.Name,
+			command: entry.Command,
+			cmd:     cmd,
+			done:    make(chan struct{}),
+		}
+
+		a.lock.Lock()
+		a.sidecars = append(a.sidecars, sc)
+		a.lock.Unlock()
+
+		a.eventAdd("sidecar_started",
+			"sidecar", sc.name,
+			"pid", cmd.Process.Pid,
+			"command", sc.command,
+		)
+
+		go func() {
+			defer close(sc.done)
+
+			r := bufio.NewReader(stdout)
+			prefix := "[" + sc.name + "] "
+
+			for {
+				line, err := r.ReadString('\n')
+				if line != "" {
+					a.lines.Append(prefix + line)
+					a.logFile.WriteLine(cmd.Process.Pid, prefix+line)
+					fmt.Fprintf(os.Stdout, "%s[%d]: %s%s", a.Name, cmd.Process.Pid, prefix, line)
+				}
+
+				if err != nil {
+					break
+				}
+			}
+
+			cmd.Wait()
+
+			a.lock.Lock()
+			stopping := a.stopping
+			a.lock.Unlock()
+
+			if !stopping {
+				a.eventAdd("sidecar_exited",
+					"sidecar", sc.name,
+					"pid", cmd.Process.Pid,
+					"exit_code", cmd.ProcessState.ExitCode(),
+				)
+				fmt.Printf("! Sidecar '%s' for '%s' exited\n", sc.name, a.Name)
+			}
+		}()
+	}
+}
+
+// startPendingSidecars starts the sidecars of an instance booted for a
+// blue/green restart, unless it is already stopping.
+func (a *App) startPendingSidecars() {
+	a.lock.Lock()
+	start := a.pendingSidecars
+	a.pendingSidecars = nil
+	stopping := a.stopping
+	a.lock.Unlock()
+
+	if start != nil && !stopping {
+		start()
+	}
+}
+
+// stopSidecars stops every sidecar the way the app itself is stopped: the
+// process group gets SIGTERM, then SIGKILL after the stop timeout. Calls
+// after the first wait for it to finish.
+func (a *App) stopSidecars() {
+	a.sidecarsStopped.Do(func() {
+		a.lock.Lock()
+		a.pendingSidecars = nil
+		sidecars := a.sidecars
+		a.lock.Unlock()
+
+		a.stopSidecarList(sidecars)
+	})
+}
+
+func (a *App) stopSidecarList(sidecars []*sidecar) {
+	var wg sync.WaitGroup
+
+	for _, sc := range sidecars {
+		wg.Add(1)
+
+		go func(sc *sidecar) {
+			defer wg.Done()
+
+			pgid := sc.cmd.Process.Pid
+
+			select {
+			case <-sc.done:
+			default:
+				syscall.Kill(-pgid, syscall.SIGTERM)
+			}
+
+			select {
+			case <-sc.done:
+			case <-time.After(a.Config.StopTimeout.Duration):
+				a.eventAdd("stop_escalated",
+					"sidecar", sc.name,
+					"pid", pgid,
+					"signal", "SIGKILL",
+				)
+				syscall.Kill(-pgid, syscall.SIGKILL)
+				<-sc.done
+			}
+
+			// Anything the sidecar left behind in its group.
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}(sc)
+	}
+
+	wg.Wait()
+
+	if len(sidecars) > 0 {
+		a.eventAdd("sidecars_stopped", "count", len(sidecars))
+	}
+}
+
+func (a *App) sidecarStatuses() []SidecarStatus {
+	var statuses []SidecarStatus
+
+	a.lock.Lock()
+	sidecars := a.sidecars
+	a.lock.Unlock()
+
+	for _, sc := range sidecars {
+		status := SidecarStatus{
+			Name:    sc.name,
+			Command: sc.command,
+			Pid:     sc.cmd.Process.Pid,
+		}
+
+		select {
+		case <-sc.done:
+			status.ExitCode = sc.cmd.ProcessState.ExitCode()
+		default:
+			status.Running = true
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
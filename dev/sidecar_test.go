@@ -0,0 +1,174 @@
+package dev
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSidecarEntries(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "sidecar-entries")
+	MakeDirectoryOrFail(t, appDir)
+
+	writeFileOrFail(t, filepath.Join(appDir, "Procfile.dev"), `
+web: bin/rails server
+css: bin/rails tailwindcss:watch
+js: yarn build --watch
+`)
+
+	cfg := &AppConfig{
+		ProcfileSidecars: "Procfile.dev",
+		Sidecars: map[string]string{
+			"worker": "bundle exec sidekiq",
+			"mail":   "mailcatcher -f",
+		},
+	}
+
+	entries, err := cfg.SidecarEntries(appDir)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []ProcfileEntry{
+		{Name: "css", Command: "bin/rails tailwindcss:watch"},
+		{Name: "js", Command: "yarn build --watch"},
+		{Name: "mail", Command: "mailcatcher -f"},
+		{Name: "worker", Command: "bundle exec sidekiq"},
+	}, entries)
+
+	cfg.ProcfileSidecars = "Procfile.missing"
+
+	_, err = cfg.SidecarEntries(appDir)
+	assert.Error(t, err)
+}
+
+func TestStartSidecars(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir, err := filepath.Abs(filepath.Join("tmp", "sidecars"))
+	assert.NoError(t, err)
+	MakeDirectoryOrFail(t, appDir)
+
+	app := &App{
+		Name:   "app",
+		Events: &Events{},
+		Config: &AppConfig{StopTimeout: Duration{time.Second}},
+		dir:    appDir,
+	}
+
+	app.startSidecars("/bin/bash", false, []ProcfileEntry{
+		{Name: "worker", Command: "echo started $GREETING; sleep 30"},
+		{Name: "stubborn", Command: "trap '' TERM; echo ignoring term; sleep 30"},
+	}, append(os.Environ(), "GREETING=hi"))
+
+	assert.Len(t, app.sidecars, 2)
+
+	waitForLog := func(want string) bool {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if strings.Contains(app.Log(), want) {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	assert.True(t, waitForLog("[worker] started hi"), app.Log())
+	assert.True(t, waitForLog("[stubborn] ignoring term"), app.Log())
+
+	for _, status := range app.sidecarStatuses() {
+		assert.True(t, status.Running, status.Name)
+	}
+
+	app.lock.Lock()
+	app.stopping = true
+	app.lock.Unlock()
+
+	app.stopSidecars()
+
+	for _, status := range app.sidecarStatuses() {
+		assert.False(t, status.Running, status.Name)
+	}
+
+	assert.Contains(t, app.Log(), `"event":"stop_escalated","app":"app","sidecar":"stubborn"`)
+}
+
+// A blue/green restart boots a second instance of the app while the first
+// one keeps running. Its sidecars must wait for the old ones to stop, or
+// anything they hold, like a port, clashes.
+func TestReplaceApp_sidecars(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	assert.NoError(t, err)
+
+	// The lock directory stands in for a fixed port.
+	writeAppOrFail(t, filepath.Join(poolDir, "app"), `
+restart_strategy = "blue-green"
+stop_timeout = "2s"
+
+[sidecars]
+lock = "mkdir lock.d || { echo clash; exit 1; }; trap 'rmdir lock.d; exit 0' TERM; echo holding; while true; do sleep 0.1; done"
+`)
+
+	pool := &AppPool{
+		Dir:         poolDir,
+		IdleTime:    time.Hour,
+		BootTimeout: 20 * time.Second,
+		StopTimeout: 2 * time.Second,
+		Events:      &Events{},
+	}
+
+	old, err := pool.lookupApp("app")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer pool.Purge()
+
+	waitFor := func(what string, cond func() bool) bool {
+		deadline := time.Now().Add(20 * time.Second)
+		for time.Now().Before(deadline) {
+			if cond() {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return assert.Fail(t, "timed out waiting for "+what)
+	}
+
+	if !waitFor("the old sidecar", func() bool { return strings.Contains(old.Log(), "[lock] holding") }) {
+		return
+	}
+
+	pool.replaceApp(old, "test", "tmp/restart.txt")
+
+	app, err := pool.lookupApp("app")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	assert.NotEqual(t, old, app)
+
+	waitFor("the new sidecar", func() bool { return strings.Contains(app.Log(), "[lock] holding") })
+
+	assert.NotContains(t, app.Log(), "[lock] clash")
+
+	for _, status := range old.sidecarStatuses() {
+		assert.False(t, status.Running, status.Name)
+	}
+
+	for _, status := range app.sidecarStatuses() {
+		assert.True(t, status.Running, status.Name)
+	}
+
+	var events bytes.Buffer
+	pool.Events.WriteTo(&events)
+	assert.NotContains(t, events.String(), `"event":"sidecar_exited"`)
+}
@@ -0,0 +1,101 @@
+package dev
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxSocketPath is the longest path a unix socket can be bound to. sun_path
+// is 108 bytes on Linux and 104 on macOS, including the trailing NUL.
+const maxSocketPath = 103
+
+// RuntimeSocketDir is where sockets go with -socket-dir runtime.
+func RuntimeSocketDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "puma-dev")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("puma-dev-%d", os.Getuid()))
+}
+
+// SetupSocketDir creates dir for app sockets and removes sockets left in it
+// by puma-dev processes that are no longer running.
+func (pool *AppPool) SetupSocketDir(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		pid, ok := socketOwner(entry.Name())
+		if !ok || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if os.Remove(path) == nil {
+			pool.Events.Add("stale_socket_removed", "path", path, "pid", pid)
+		}
+	}
+
+	pool.SocketDir = dir
+
+	return nil
+}
+
+// socketOwner returns the puma-dev pid a socket in the socket dir was
+// created by.
+func socketOwner(name string) (int, bool) {
+	if !strings.HasSuffix(name, ".sock") {
+		return 0, false
+	}
+
+	dash := strings.IndexByte(name, '-')
+	if dash == -1 {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(name[:dash])
+	if err != nil {
+		return 0, false
+	}
+
+	return pid, true
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// socketPath picks the socket an app instance listens on. Without a socket
+// dir it goes in the app's tmp dir, otherwise it gets a short name in the
+// socket dir so the path stays under the unix socket length limit.
+func (pool *AppPool) socketPath(name, tmpDir, instance string) (string, error) {
+	var socket string
+
+	if pool.SocketDir == "" {
+		socket = filepath.Join(tmpDir, fmt.Sprintf("puma-dev-%d%s.sock", os.Getpid(), instance))
+	} else {
+		h := sha1.New()
+		h.Write([]byte(name + instance))
+		socket = filepath.Join(pool.SocketDir, fmt.Sprintf("%d-%.4x.sock", os.Getpid(), h.Sum(nil)))
+	}
+
+	if len(socket) > maxSocketPath {
+		return "", fmt.Errorf("socket path %s is longer than %d bytes, which unix sockets don't support. Run puma-dev with -socket-dir to keep sockets elsewhere", socket, maxSocketPath)
+	}
+
+	return socket, nil
+}
@@ -0,0 +1,63 @@
+package dev
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketPath(t *testing.T) {
+	pool := &AppPool{}
+
+	socket, err := pool.socketPath("app", "/apps/app/tmp", "")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("/apps/app/tmp/puma-dev-%d.sock", os.Getpid()), socket)
+
+	_, err = pool.socketPath("app", "/"+strings.Repeat("deep/", 25)+"tmp", "")
+	assert.Error(t, err)
+
+	pool.SocketDir = "/run/user/1000/puma-dev"
+
+	socket, err = pool.socketPath("app", "/"+strings.Repeat("deep/", 25)+"tmp", "")
+	assert.NoError(t, err)
+	assert.Regexp(t, fmt.Sprintf(`^/run/user/1000/puma-dev/%d-[0-9a-f]{8}\.sock$`, os.Getpid()), socket)
+
+	other, err := pool.socketPath("app", "", "-1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, socket, other)
+}
+
+func TestSetupSocketDir_removesStaleSockets(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	dir := filepath.Join("tmp", "sockets")
+
+	// A pid that is certainly not running anymore.
+	cmd := exec.Command("true")
+	assert.NoError(t, cmd.Run())
+	dead := cmd.Process.Pid
+
+	MakeDirectoryOrFail(t, dir)
+
+	stale := filepath.Join(dir, fmt.Sprintf("%d-abcd1234.sock", dead))
+	ours := filepath.Join(dir, fmt.Sprintf("%d-abcd1234.sock", os.Getpid()))
+	other := filepath.Join(dir, "notes.txt")
+
+	for _, path := range []string{stale, ours, other} {
+		writeFileOrFail(t, path, "")
+	}
+
+	pool := &AppPool{Events: &Events{}}
+	assert.NoError(t, pool.SetupSocketDir(dir))
+	assert.Equal(t, dir, pool.SocketDir)
+
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, ours)
+	assert.FileExists(t, other)
+}
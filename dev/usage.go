@@ -0,0 +1,115 @@
+package dev
+
+import (
+	"time"
+)
+
+// procInfo is what puma-dev needs to know about a single process.
+type procInfo struct {
+	ppid    int
+	rss     uint64
+	cpuTime time.Duration
+}
+
+// AppUsage is the resource usage of an app's whole process tree.
+type AppUsage struct {
+	Processes  int     `json:"processes"`
+	RSS        uint64  `json:"rss_bytes"`
+	CPUPercent float64 `json:"cpu_percent"`
+
+	cpuTime time.Duration
+	sampled time.Time
+}
+
+// treeUsage adds up the usage of root and all its descendants.
+func treeUsage(procs map[int]procInfo, root int) AppUsage {
+	children := map[int][]int{}
+	for pid, info := range procs {
+		children[info.ppid] = append(children[info.ppid], pid)
+	}
+
+	var usage AppUsage
+
+	queue := []int{root}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+
+		info, ok := procs[pid]
+		if !ok {
+			continue
+		}
+
+		usage.Processes++
+		usage.RSS += info.rss
+		usage.cpuTime += info.cpuTime
+
+		queue = append(queue, children[pid]...)
+	}
+
+	return usage
+}
+
+// sampleUsage measures the app's current usage. CPU is averaged since the
+// previous sample, which needs at least a second between samples to mean
+// anything, so closer samples keep the previous figure.
+func (a *App) sampleUsage() (*AppUsage, error) {
+	if a.Command == nil || a.Command.Process == nil {
+		return nil, nil
+	}
+
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := treeUsage(procs, a.Command.Process.Pid)
+	usage.sampled = time.Now()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if prev := a.usage; prev != nil {
+		elapsed := usage.sampled.Sub(prev.sampled)
+		if elapsed >= time.Second {
+			used := usage.cpuTime - prev.cpuTime
+			if used < 0 {
+				used = 0
+			}
+			usage.CPUPercent = 100 * used.Seconds() / elapsed.Seconds()
+		} else {
+			usage.CPUPercent = prev.CPUPercent
+			usage.cpuTime = prev.cpuTime
+			usage.sampled = prev.sampled
+		}
+	}
+
+	a.usage = &usage
+
+	result := usage
+	return &result, nil
+}
+
+// checkMemory restarts the app if it has grown past its max_rss. It returns
+// true if the app was killed.
+func (a *App) checkMemory() bool {
+	usage, err := a.sampleUsage()
+	if err != nil || usage == nil {
+		return false
+	}
+
+	limit := a.Config.MaxRSS
+	if limit == 0 || usage.RSS <= uint64(limit) {
+		return false
+	}
+
+	a.eventAdd("rss_exceeded",
+		"rss", ByteSize(usage.RSS).String(),
+		"max_rss", limit.String(),
+		"processes", usage.Processes,
+	)
+
+	a.Kill("rss exceeded")
+
+	return true
+}
@@ -0,0 +1,62 @@
+package dev
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listProcesses asks ps for every process, since macOS has no /proc.
+func listProcesses() (map[int]procInfo, error) {
+	out, err := exec.Command("ps", "-axo", "pid=,ppid=,rss=,time=").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	procs := map[int]procInfo{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		ppid, _ := strconv.Atoi(fields[1])
+		rss, _ := strconv.ParseUint(fields[2], 10, 64)
+
+		procs[pid] = procInfo{
+			ppid:    ppid,
+			rss:     rss * 1024,
+			cpuTime: parseCPUTime(fields[3]),
+		}
+	}
+
+	return procs, scanner.Err()
+}
+
+// parseCPUTime parses the [[dd-]hh:]mm:ss.cc format used by ps.
+func parseCPUTime(s string) time.Duration {
+	var days time.Duration
+	if i := strings.IndexByte(s, '-'); i != -1 {
+		d, _ := strconv.Atoi(s[:i])
+		days = time.Duration(d) * 24 * time.Hour
+		s = s[i+1:]
+	}
+
+	var total float64
+	for _, part := range strings.Split(s, ":") {
+		n, _ := strconv.ParseFloat(part, 64)
+		total = total*60 + n
+	}
+
+	return days + time.Duration(total*float64(time.Second))
+}
@@ -0,0 +1,73 @@
+package dev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every Linux platform puma-dev runs
+// on.
+const clockTicks = 100
+
+// listProcesses reads every process from /proc.
+func listProcesses() (map[int]procInfo, error) {
+	paths, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	procs := map[int]procInfo{}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			// The process exited since we listed it.
+			continue
+		}
+
+		pid, info, ok := parseProcStat(string(data), pageSize)
+		if ok {
+			procs[pid] = info
+		}
+	}
+
+	return procs, nil
+}
+
+// parseProcStat parses a /proc/<pid>/stat line. The command name is in
+// parens and can itself contain spaces and parens, so fields are counted
+// from the last ')'.
+func parseProcStat(line string, pageSize uint64) (int, procInfo, bool) {
+	lparen := strings.IndexByte(line, '(')
+	rparen := strings.LastIndexByte(line, ')')
+	if lparen == -1 || rparen == -1 {
+		return 0, procInfo{}, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(line[:lparen]))
+	if err != nil {
+		return 0, procInfo{}, false
+	}
+
+	// fields[0] is the state, field 3 of the whole line.
+	fields := strings.Fields(line[rparen+1:])
+	if len(fields) < 22 {
+		return 0, procInfo{}, false
+	}
+
+	ppid, _ := strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	rss, _ := strconv.ParseUint(fields[21], 10, 64)
+
+	return pid, procInfo{
+		ppid:    ppid,
+		rss:     rss * pageSize,
+		cpuTime: time.Duration(utime+stime) * time.Second / clockTicks,
+	}, true
+}
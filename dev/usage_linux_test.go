@@ -0,0 +1,33 @@
+package dev
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcStat(t *testing.T) {
+	line := "4321 (ruby (puma) 1) S 4300 4321 4321 0 -1 4194560 1 0 0 0 150 50 0 0 20 0 2 0 1 2 2560 0 0\n"
+
+	pid, info, ok := parseProcStat(line, 4096)
+	assert.True(t, ok)
+	assert.Equal(t, 4321, pid)
+	assert.Equal(t, 4300, info.ppid)
+	assert.Equal(t, uint64(2560*4096), info.rss)
+	assert.Equal(t, 2*time.Second, info.cpuTime)
+
+	_, _, ok = parseProcStat("garbage", 4096)
+	assert.False(t, ok)
+}
+
+func TestListProcesses_includesSelf(t *testing.T) {
+	procs, err := listProcesses()
+	assert.NoError(t, err)
+
+	info, ok := procs[os.Getpid()]
+	assert.True(t, ok)
+	assert.Equal(t, os.Getppid(), info.ppid)
+	assert.NotZero(t, info.rss)
+}
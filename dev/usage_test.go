@@ -0,0 +1,76 @@
+package dev
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreeUsage(t *testing.T) {
+	procs := map[int]procInfo{
+		1:  {ppid: 0, rss: 1000, cpuTime: time.Hour},
+		10: {ppid: 1, rss: 100, cpuTime: time.Second},
+		11: {ppid: 10, rss: 20, cpuTime: 2 * time.Second},
+		12: {ppid: 10, rss: 30, cpuTime: 3 * time.Second},
+		13: {ppid: 12, rss: 5, cpuTime: 0},
+		20: {ppid: 1, rss: 999, cpuTime: time.Minute},
+	}
+
+	usage := treeUsage(procs, 10)
+	assert.Equal(t, 4, usage.Processes)
+	assert.Equal(t, uint64(155), usage.RSS)
+	assert.Equal(t, 6*time.Second, usage.cpuTime)
+
+	usage = treeUsage(procs, 99)
+	assert.Equal(t, 0, usage.Processes)
+}
+
+func TestCheckMemory_restart(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	assert.NoError(t, err)
+
+	// The short idle timeout makes the app check its memory every second,
+	// and memory is checked before idleness.
+	writeAppOrFail(t, filepath.Join(poolDir, "app"), `
+max_rss = "1KB"
+idle_timeout = "1s"
+`)
+
+	pool := &AppPool{
+		Dir:         poolDir,
+		BootTimeout: 20 * time.Second,
+		StopTimeout: time.Second,
+		Events:      &Events{},
+	}
+	defer pool.Purge()
+
+	app, err := pool.lookupApp("app")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	select {
+	case <-app.t.Dead():
+	case <-time.After(10 * time.Second):
+		assert.FailNow(t, "app over max_rss was not stopped")
+	}
+
+	var events bytes.Buffer
+	pool.Events.WriteTo(&events)
+	assert.Contains(t, events.String(), `"event":"rss_exceeded","app":"app"`)
+	assert.Contains(t, events.String(), `"reason":"rss exceeded"`)
+	assert.NotContains(t, events.String(), `"event":"idle_app"`)
+
+	relaunched, err := pool.lookupApp("app")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	assert.NotEqual(t, app, relaunched)
+}
@@ -0,0 +1,122 @@
+package dev
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VariantSeparator separates an app's name from the profile a variant of
+// it runs with, as in myapp--test.
+const VariantSeparator = "--"
+
+// splitVariant splits a variant name like myapp--test into the app and
+// profile names. profile is empty if name isn't a variant.
+func splitVariant(name string) (app, profile string) {
+	i := strings.LastIndex(name, VariantSeparator)
+	if i <= 0 {
+		return name, ""
+	}
+
+	app, profile = name[:i], name[i+len(VariantSeparator):]
+	if profile == "" || strings.HasPrefix(profile, "-") || strings.Contains(profile, ".") {
+		return name, ""
+	}
+
+	return app, profile
+}
+
+// isVariant reports whether name is a variant of an app in the pool whose
+// config has the profile, like myapp--test. Those aren't apps in subdirs,
+// even though myapp/test usually exists.
+func (a *AppPool) isVariant(name string) bool {
+	base, profile := splitVariant(name)
+	if profile == "" {
+		return false
+	}
+
+	path := filepath.Join(a.Dir, base)
+
+	stat, err := os.Stat(path)
+	if err != nil || !stat.IsDir() {
+		return false
+	}
+
+	cfg, err := a.LoadConfig(path)
+	if err != nil {
+		return false
+	}
+
+	_, ok := cfg.Profiles[profile]
+	return ok
+}
+
+// lookupVariant finds the app for a variant like myapp--test, which runs
+// myapp with the env of the "test" profile in its config. It is launched
+// if it isn't running, next to myapp itself. It returns ErrUnknownApp if
+// name isn't a variant of an app that has the profile.
+func (a *AppPool) lookupVariant(name string) (*App, error) {
+	base, profile := splitVariant(name)
+	if profile == "" {
+		return nil, ErrUnknownApp
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.apps == nil {
+		a.apps = make(map[string]*App)
+	}
+
+	path := filepath.Join(a.Dir, base)
+
+	stat, err := os.Stat(path)
+	if err != nil || !stat.IsDir() {
+		return nil, ErrUnknownApp
+	}
+
+	cfg, err := a.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := cfg.Profiles[profile]; !ok {
+		return nil, ErrUnknownApp
+	}
+
+	canonicalName := base
+
+	// Use the same name as the app itself would get, so all links to it
+	// share the variant too.
+	destPath, _ := os.Readlink(path)
+	if destStat, err := os.Stat(destPath); err == nil {
+		h := sha1.New()
+		h.Write([]byte(destPath))
+		canonicalName = fmt.Sprintf("%s-%.4x", destStat.Name(), h.Sum(nil))
+	}
+
+	canonicalName += VariantSeparator + profile
+
+	app, ok := a.apps[canonicalName]
+	if !ok {
+		err = a.checkCrashLoop(canonicalName, path)
+		if err != nil {
+			return nil, err
+		}
+
+		a.makeRoom()
+
+		app, err = a.launchApp(canonicalName, path, profile, "")
+		if err != nil {
+			a.Events.Add("error_starting_app", "app", canonicalName, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	a.apps[canonicalName] = app
+	a.apps[name] = app
+
+	return app, nil
+}
@@ -0,0 +1,89 @@
+package dev
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitVariant(t *testing.T) {
+	for name, expected := range map[string][2]string{
+		"myapp--test":         {"myapp", "test"},
+		"my-app--feature-x":   {"my-app", "feature-x"},
+		"www.myapp--test":     {"www.myapp", "test"},
+		"myapp":               {"myapp", ""},
+		"myapp--":             {"myapp--", ""},
+		"--test":              {"--test", ""},
+		"myapp--test.example": {"myapp--test.example", ""},
+	} {
+		app, profile := splitVariant(name)
+		assert.Equal(t, expected, [2]string{app, profile}, name)
+	}
+}
+
+func TestLookupVariant_unknown(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	appDir := filepath.Join("tmp", "variant-app")
+	MakeDirectoryOrFail(t, appDir)
+
+	writeFileOrFail(t, filepath.Join(appDir, AppConfigFile), `
+[profiles.test.env]
+RAILS_ENV = "test"
+`)
+
+	pool := &AppPool{Dir: "tmp", Events: &Events{}}
+
+	_, err := pool.lookupVariant("variant-app")
+	assert.Equal(t, ErrUnknownApp, err)
+
+	_, err = pool.lookupVariant("variant-app--staging")
+	assert.Equal(t, ErrUnknownApp, err)
+
+	_, err = pool.lookupVariant("missing--test")
+	assert.Equal(t, ErrUnknownApp, err)
+}
+
+// Rails apps have a test directory, which the myapp--test variant must not
+// be mistaken for as an app in a subdir.
+func TestFindAppByDomainName_variant(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	dir := filepath.Join(poolDir, "myapp")
+	writeAppOrFail(t, dir, `
+[profiles.test.env]
+RAILS_ENV = "test"
+`)
+
+	MakeDirectoryOrFail(t, filepath.Join(dir, "test"))
+
+	pool := &AppPool{
+		Dir:         poolDir,
+		IdleTime:    time.Hour,
+		BootTimeout: 20 * time.Second,
+		StopTimeout: 2 * time.Second,
+		Events:      &Events{},
+	}
+	defer pool.Purge()
+
+	app, err := pool.FindAppByDomainName("myapp--test")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	assert.Equal(t, "myapp--test", app.Name)
+	assert.Equal(t, dir, app.dir)
+	assert.Equal(t, "test", app.profile)
+
+	again, err := pool.FindAppByDomainName("myapp--test")
+	assert.NoError(t, err)
+	assert.Equal(t, app, again)
+}
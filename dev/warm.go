@@ -0,0 +1,79 @@
+package dev
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// keepWarmNames returns the names of apps to keep running: those passed in
+// Prewarm plus any app in Dir whose config sets keep_warm.
+func (pool *AppPool) keepWarmNames() []string {
+	names := append([]string{}, pool.Prewarm...)
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		seen[name] = true
+	}
+
+	entries, err := ioutil.ReadDir(pool.Dir)
+	if err != nil {
+		return names
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if seen[name] {
+			continue
+		}
+
+		path := filepath.Join(pool.Dir, name)
+
+		stat, err := os.Stat(path)
+		if err != nil || !stat.IsDir() {
+			continue
+		}
+
+		cfg, err := pool.LoadConfig(path)
+		if err != nil || !cfg.KeepWarm {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Warm boots every keep warm app that isn't already running. Keep warm apps
+// are never stopped for being idle.
+func (pool *AppPool) Warm() {
+	for _, name := range pool.keepWarmNames() {
+		app, err := pool.lookupApp(name)
+		if err == ErrUnknownApp {
+			app, err = pool.lookupVariant(name)
+		}
+
+		if err != nil {
+			pool.Events.Add("prewarm_error", "app", name, "error", err.Error())
+			fmt.Printf("! Unable to prewarm '%s': %s\n", name, err)
+			continue
+		}
+
+		pool.lock.Lock()
+		if pool.keepWarm == nil {
+			pool.keepWarm = make(map[string]bool)
+		}
+		pool.keepWarm[app.Name] = true
+		pool.lock.Unlock()
+
+		app.eventAdd("prewarming_app")
+	}
+}
+
+// keptWarm reports whether app is never stopped for being idle or to make
+// room for another app. It must be called with the pool locked.
+func (pool *AppPool) keptWarm(app *App) bool {
+	return pool.keepWarm[app.Name] || app.Config.KeepWarm
+}
@@ -0,0 +1,79 @@
+package dev
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepWarmNames(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir := filepath.Join("tmp", "keep-warm")
+	MakeDirectoryOrFail(t, filepath.Join(poolDir, "cold"))
+	MakeDirectoryOrFail(t, filepath.Join(poolDir, "warm"))
+	MakeDirectoryOrFail(t, filepath.Join(poolDir, "global"))
+
+	writeFileOrFail(t, filepath.Join(poolDir, "warm", AppConfigFile), "keep_warm = true\n")
+	writeFileOrFail(t, filepath.Join(poolDir, "global.toml"), "keep_warm = true\n")
+	writeFileOrFail(t, filepath.Join(poolDir, "proxy"), "3000\n")
+
+	pool := &AppPool{Dir: poolDir, Prewarm: []string{"cold", "warm"}}
+
+	assert.Equal(t, []string{"cold", "warm", "global"}, pool.keepWarmNames())
+}
+
+func TestMaybeIdle_keepWarm(t *testing.T) {
+	pool := &AppPool{}
+
+	app := &App{Config: &AppConfig{KeepWarm: true}}
+	assert.False(t, pool.maybeIdle(app))
+
+	pool.keepWarm = map[string]bool{"warm": true}
+	app = &App{Name: "warm", Config: &AppConfig{}}
+	assert.False(t, pool.maybeIdle(app))
+}
+
+func TestWarm_relaunch(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	assert.NoError(t, err)
+
+	writeAppOrFail(t, filepath.Join(poolDir, "app"), "")
+
+	pool := &AppPool{
+		Dir:         poolDir,
+		IdleTime:    time.Millisecond,
+		BootTimeout: 20 * time.Second,
+		StopTimeout: time.Second,
+		Prewarm:     []string{"app"},
+		Events:      &Events{},
+	}
+	defer pool.Purge()
+
+	pool.Warm()
+
+	app, err := pool.lookupApp("app")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	app.Kill("test")
+	<-app.t.Dead()
+
+	relaunched, err := pool.lookupApp("app")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	assert.NotEqual(t, app, relaunched)
+	assert.False(t, pool.maybeIdle(relaunched), "relaunched app was idled out")
+
+	pool.lock.Lock()
+	assert.True(t, pool.keptWarm(relaunched))
+	pool.lock.Unlock()
+}
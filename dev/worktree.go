@@ -0,0 +1,293 @@
+package dev
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vektra/errors"
+)
+
+// worktreeDir is where worktrees for branch subdomains are created, inside
+// the repository's git dir so they don't show up in the app itself.
+const worktreeDir = "puma-dev-worktrees"
+
+// worktreeCopyFiles are copied from the app into new worktrees when they
+// are missing there, because they usually aren't committed.
+var worktreeCopyFiles = []string{".env", ".powrc", ".powenv", ".pumaenv", ".puma-dev.toml"}
+
+// branchLabel returns the DNS label a branch is reached at, for example
+// "feature-login" for "feature/Login".
+func branchLabel(branch string) string {
+	var (
+		buf  strings.Builder
+		dash bool
+	)
+
+	for _, r := range strings.ToLower(branch) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && buf.Len() > 0 {
+				buf.WriteByte('-')
+			}
+			dash = false
+			buf.WriteRune(r)
+		} else {
+			dash = true
+		}
+	}
+
+	return buf.String()
+}
+
+// git runs a git command in dir and returns its output. Errors include
+// what git printed.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(ee.Stderr)))
+		}
+
+		return "", errors.Context(err, "running git "+args[0])
+	}
+
+	return string(out), nil
+}
+
+// gitCommonDir returns the git dir shared by all worktrees of the
+// repository dir is in, or an empty string if it isn't in one.
+func gitCommonDir(dir string) string {
+	out, err := git(dir, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return ""
+	}
+
+	common := strings.TrimSpace(out)
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+
+	return common
+}
+
+// findBranch returns the local or remote branch of the repository in dir
+// whose label is label, or an empty string if there is none.
+func findBranch(dir, label string) (string, error) {
+	out, err := git(dir, "for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes")
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		local   string
+		remotes = map[string]bool{}
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		ref := scanner.Text()
+
+		if branch := strings.TrimPrefix(ref, "refs/heads/"); branch != ref {
+			if branchLabel(branch) == label {
+				if local != "" {
+					return "", fmt.Errorf("'%s' matches several branches: %s and %s", label, local, branch)
+				}
+				local = branch
+			}
+			continue
+		}
+
+		// refs/remotes/<remote>/<branch>
+		parts := strings.SplitN(strings.TrimPrefix(ref, "refs/remotes/"), "/", 2)
+		if len(parts) == 2 && parts[1] != "HEAD" && branchLabel(parts[1]) == label {
+			remotes[parts[1]] = true
+		}
+	}
+
+	if local != "" {
+		return local, nil
+	}
+
+	var branches []string
+	for branch := range remotes {
+		branches = append(branches, branch)
+	}
+
+	switch len(branches) {
+	case 0:
+		return "", nil
+	case 1:
+		return branches[0], nil
+	default:
+		sort.Strings(branches)
+		return "", fmt.Errorf("'%s' matches several branches: %s", label, strings.Join(branches, ", "))
+	}
+}
+
+// worktreePaths returns the worktrees of the repository in dir by the
+// branch they have checked out.
+func worktreePaths(dir string) (map[string]string, error) {
+	out, err := git(dir, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]string{}
+
+	var path string
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			path = strings.TrimPrefix(line, "worktree ")
+		case strings.HasPrefix(line, "branch refs/heads/"):
+			if _, err := os.Stat(path); err == nil {
+				paths[strings.TrimPrefix(line, "branch refs/heads/")] = path
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// worktreeFor returns the worktree of the repository in dir that has
+// branch checked out, creating one if there isn't one yet. created reports
+// whether it was just created.
+func worktreeFor(dir, commonDir, branch string) (path string, created bool, err error) {
+	paths, err := worktreePaths(dir)
+	if err != nil {
+		return "", false, err
+	}
+
+	if path, ok := paths[branch]; ok {
+		return path, false, nil
+	}
+
+	path = filepath.Join(commonDir, worktreeDir, branchLabel(branch))
+
+	// git checks out remote branches as a new local tracking branch.
+	if _, err := git(dir, "worktree", "add", path, branch); err != nil {
+		return "", false, err
+	}
+
+	for _, name := range worktreeCopyFiles {
+		dest := filepath.Join(path, name)
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		ioutil.WriteFile(dest, data, 0644)
+	}
+
+	return path, true, nil
+}
+
+// lookupWorktree finds the app for a branch subdomain like
+// feature-login.myapp, launching the branch's worktree of myapp if it
+// isn't running. It returns ErrUnknownApp if name isn't an app in a git
+// repository, label doesn't match a branch, or the branch is the one the
+// app itself has checked out. git runs without the pool locked, since
+// checking out a worktree can take a while.
+func (a *AppPool) lookupWorktree(label, name string) (*App, error) {
+	a.worktreeLock.Lock()
+	defer a.worktreeLock.Unlock()
+
+	dir, err := filepath.EvalSymlinks(filepath.Join(a.Dir, name))
+	if err != nil {
+		return nil, ErrUnknownApp
+	}
+
+	if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+		return nil, ErrUnknownApp
+	}
+
+	h := sha1.New()
+	h.Write([]byte(dir))
+	canonicalName := fmt.Sprintf("%s.%s-%.4x", label, filepath.Base(dir), h.Sum(nil))
+
+	a.lock.Lock()
+	app, ok := a.apps[canonicalName]
+	if ok {
+		a.apps[label+"."+name] = app
+	}
+	a.lock.Unlock()
+
+	if ok {
+		return app, nil
+	}
+
+	commonDir := gitCommonDir(dir)
+	if commonDir == "" {
+		return nil, ErrUnknownApp
+	}
+
+	branch, err := findBranch(dir, label)
+	if err != nil {
+		a.Events.Add("worktree_error", "app", name, "branch", label, "error", err.Error())
+		return nil, err
+	}
+
+	if branch == "" {
+		return nil, ErrUnknownApp
+	}
+
+	path, created, err := worktreeFor(dir, commonDir, branch)
+	if err != nil {
+		a.Events.Add("worktree_error", "app", name, "branch", branch, "error", err.Error())
+		return nil, err
+	}
+
+	if path == dir {
+		return nil, ErrUnknownApp
+	}
+
+	if created {
+		a.Events.Add("worktree_created", "app", name, "branch", branch, "path", path)
+		fmt.Printf("* Created worktree for branch '%s' of '%s' in %s\n", branch, name, path)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.apps == nil {
+		a.apps = make(map[string]*App)
+	}
+
+	app, ok = a.apps[canonicalName]
+	if !ok {
+		err = a.checkCrashLoop(canonicalName, path)
+		if err != nil {
+			return nil, err
+		}
+
+		a.makeRoom()
+
+		app, err = a.LaunchApp(canonicalName, path)
+		if err != nil {
+			a.Events.Add("error_starting_app", "app", canonicalName, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	a.apps[canonicalName] = app
+	a.apps[label+"."+name] = app
+
+	return app, nil
+}
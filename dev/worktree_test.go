@@ -0,0 +1,173 @@
+package dev
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func gitOrFail(t *testing.T, dir string, args ...string) {
+	args = append([]string{"-c", "user.name=puma-dev", "-c", "user.email=puma-dev@example.com"}, args...)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		assert.FailNow(t, err.Error(), string(out))
+	}
+}
+
+func TestBranchLabel(t *testing.T) {
+	assert.Equal(t, "main", branchLabel("main"))
+	assert.Equal(t, "feature-login", branchLabel("feature/Login"))
+	assert.Equal(t, "fix-n-1-bug", branchLabel("fix_N+1__bug_"))
+}
+
+func TestWorktreeFor(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	dir, err := filepath.Abs(filepath.Join("tmp", "worktree-app"))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	MakeDirectoryOrFail(t, dir)
+
+	gitOrFail(t, dir, "init", "-q", "-b", "main")
+	writeFileOrFail(t, filepath.Join(dir, "config.ru"), "run App\n")
+	gitOrFail(t, dir, "add", "config.ru")
+	gitOrFail(t, dir, "commit", "-q", "-m", "initial")
+	gitOrFail(t, dir, "branch", "feature/Login")
+
+	writeFileOrFail(t, filepath.Join(dir, ".env"), "SECRET=1\n")
+
+	commonDir := gitCommonDir(dir)
+	assert.Equal(t, filepath.Join(dir, ".git"), commonDir)
+
+	branch, err := findBranch(dir, "feature-login")
+	assert.NoError(t, err)
+	assert.Equal(t, "feature/Login", branch)
+
+	path, created, err := worktreeFor(dir, commonDir, branch)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, filepath.Join(dir, ".git", worktreeDir, "feature-login"), path)
+
+	// Files that usually aren't committed are copied over.
+	assert.FileExists(t, filepath.Join(path, ".env"))
+
+	again, created, err := worktreeFor(dir, commonDir, branch)
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, path, again)
+
+	// The branch the app has checked out is the app itself.
+	main, _, err := worktreeFor(dir, commonDir, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, dir, main)
+
+	branch, err = findBranch(dir, "missing")
+	assert.NoError(t, err)
+	assert.Equal(t, "", branch)
+
+	gitOrFail(t, dir, "branch", "feature_login")
+
+	_, err = findBranch(dir, "feature-login")
+	assert.Error(t, err)
+}
+
+func TestWorktreeFor_remoteBranch(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	origin, err := filepath.Abs(filepath.Join("tmp", "worktree-origin"))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	MakeDirectoryOrFail(t, origin)
+
+	gitOrFail(t, origin, "init", "-q", "-b", "main")
+	gitOrFail(t, origin, "commit", "-q", "--allow-empty", "-m", "initial")
+	gitOrFail(t, origin, "branch", "review-me")
+
+	dir := filepath.Join(filepath.Dir(origin), "worktree-clone")
+	gitOrFail(t, filepath.Dir(origin), "clone", "-q", origin, dir)
+
+	branch, err := findBranch(dir, "review-me")
+	assert.NoError(t, err)
+	assert.Equal(t, "review-me", branch)
+
+	path, created, err := worktreeFor(dir, gitCommonDir(dir), branch)
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	_, err = os.Stat(filepath.Join(path, ".git"))
+	assert.NoError(t, err)
+
+	// The worktree has a local branch tracking the remote one now.
+	branch, err = findBranch(dir, "review-me")
+	assert.NoError(t, err)
+	assert.Equal(t, "review-me", branch)
+}
+
+func TestFindAppByDomainName_worktree(t *testing.T) {
+	defer MakeDirectoryOrFail(t, "tmp")()
+
+	poolDir, err := filepath.Abs(filepath.Join("tmp", "pool"))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	dir := filepath.Join(poolDir, "myapp")
+	writeAppOrFail(t, dir, "")
+
+	gitOrFail(t, dir, "init", "-q", "-b", "main")
+	gitOrFail(t, dir, "add", "server.py", AppConfigFile)
+	gitOrFail(t, dir, "commit", "-q", "-m", "initial")
+	gitOrFail(t, dir, "branch", "feature")
+
+	pool := &AppPool{
+		Dir:          poolDir,
+		IdleTime:     time.Hour,
+		BootTimeout:  20 * time.Second,
+		StopTimeout:  2 * time.Second,
+		GitWorktrees: true,
+		Events:       &Events{},
+	}
+	defer pool.Purge()
+
+	app, err := pool.FindAppByDomainName("nobranch.myapp")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	assert.Equal(t, "myapp", app.Name)
+
+	// The fallback is remembered, so git isn't asked again while the app
+	// runs, even if the branch shows up.
+	gitOrFail(t, dir, "branch", "nobranch")
+
+	again, err := pool.FindAppByDomainName("nobranch.myapp")
+	assert.NoError(t, err)
+	assert.Equal(t, app, again)
+
+	branch, err := pool.FindAppByDomainName("feature.myapp")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	assert.NotEqual(t, app, branch)
+	assert.Equal(t, filepath.Join(dir, ".git", worktreeDir, "feature"), branch.dir)
+
+	app.Kill("test")
+
+	again, err = pool.FindAppByDomainName("nobranch.myapp")
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(dir, ".git", worktreeDir, "nobranch"), again.dir)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/puma/puma-dev
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/avast/retry-go v2.5.0+incompatible
 	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f
 	github.com/fsnotify/fsevents v0.1.1
@@ -12,6 +12,7 @@ type LineBuffer struct {
 
 	lock  sync.Mutex
 	cur   int
+	total int
 	lines []string
 }
 
@@ -23,6 +24,8 @@ func (lb *LineBuffer) Append(line string) error {
 		lb.Size = DefaultSize
 	}
 
+	lb.total++
+
 	if len(lb.lines) < lb.Size {
 		lb.lines = append(lb.lines, line)
 	} else {
@@ -41,10 +44,40 @@ func (lb *LineBuffer) Do(x func(string) error) error {
 	lb.lock.Lock()
 	defer lb.lock.Unlock()
 
+	return lb.do(0, x)
+}
+
+// Count returns how many lines have ever been appended to the buffer.
+func (lb *LineBuffer) Count() int {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	return lb.total
+}
+
+// DoFrom is like Do but skips the first n lines ever appended, so callers
+// can follow the buffer by passing back the count it returns.
+func (lb *LineBuffer) DoFrom(n int, x func(string) error) (int, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	skip := n - (lb.total - len(lb.lines))
+	if skip < 0 {
+		skip = 0
+	}
+
+	return lb.total, lb.do(skip, x)
+}
+
+func (lb *LineBuffer) do(skip int, x func(string) error) error {
 	var err error
 
 	if len(lb.lines) < lb.Size {
-		for _, l := range lb.lines {
+		if skip > len(lb.lines) {
+			skip = len(lb.lines)
+		}
+
+		for _, l := range lb.lines[skip:] {
 			err = x(l)
 			if err != nil {
 				return err
@@ -55,6 +88,11 @@ func (lb *LineBuffer) Do(x func(string) error) error {
 	}
 
 	for i := lb.cur; i < lb.Size; i++ {
+		if skip > 0 {
+			skip--
+			continue
+		}
+
 		err = x(lb.lines[i])
 		if err != nil {
 			return err
@@ -62,6 +100,11 @@ func (lb *LineBuffer) Do(x func(string) error) error {
 	}
 
 	for i := 0; i < lb.cur; i++ {
+		if skip > 0 {
+			skip--
+			continue
+		}
+
 		err = x(lb.lines[i])
 		if err != nil {
 			return err
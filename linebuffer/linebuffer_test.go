@@ -72,4 +72,46 @@ func TestLineBuffer(t *testing.T) {
 		assert.Equal(t, "hello7", lines[2])
 	})
 
+	t.Run("follows new lines with DoFrom", func(t *testing.T) {
+		var lb LineBuffer
+
+		lb.Size = 3
+
+		lb.Append("hello1")
+		lb.Append("hello2")
+
+		var lines []string
+		collect := func(x string) error {
+			lines = append(lines, x)
+			return nil
+		}
+
+		n, err := lb.DoFrom(0, collect)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []string{"hello1", "hello2"}, lines)
+
+		lines = nil
+
+		lb.Append("hello3")
+		lb.Append("hello4")
+
+		n, err = lb.DoFrom(n, collect)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, 4, lb.Count())
+		assert.Equal(t, []string{"hello3", "hello4"}, lines)
+
+		lines = nil
+
+		lb.Append("hello5")
+		lb.Append("hello6")
+		lb.Append("hello7")
+		lb.Append("hello8")
+
+		n, err = lb.DoFrom(n, collect)
+		assert.NoError(t, err)
+		assert.Equal(t, 8, n)
+		assert.Equal(t, []string{"hello6", "hello7", "hello8"}, lines, "lines already rotated out are skipped")
+	})
 }
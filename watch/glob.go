@@ -0,0 +1,112 @@
+package watch
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Match reports whether the slash separated name matches pattern. Besides
+// the syntax supported by path.Match, a "**" path segment matches any
+// number of directories.
+func Match(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// MatchAny returns the first of patterns that name matches.
+func MatchAny(patterns []string, name string) (string, bool) {
+	for _, pattern := range patterns {
+		if Match(pattern, name) {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
+
+// staticPrefix returns the leading directories of pattern that contain no
+// wildcards, and whether anything below that may be more than one level
+// deep.
+func staticPrefix(pattern string) (string, bool) {
+	segments := strings.Split(pattern, "/")
+
+	var dirs []string
+	for _, seg := range segments[:len(segments)-1] {
+		if strings.ContainsAny(seg, "*?[\\") {
+			break
+		}
+		dirs = append(dirs, seg)
+	}
+
+	recursive := len(segments)-len(dirs) > 1
+
+	if len(dirs) == 0 {
+		return ".", recursive
+	}
+
+	return path.Join(dirs...), recursive
+}
+
+// debouncer collects changes until quiet has passed without another one,
+// then reports the first change of the burst.
+type debouncer struct {
+	quiet time.Duration
+	first string
+	timer *time.Timer
+	C     <-chan time.Time
+}
+
+func (d *debouncer) changed(name string) {
+	if d.timer == nil {
+		d.first = name
+		d.timer = time.NewTimer(d.quiet)
+		d.C = d.timer.C
+		return
+	}
+
+	if !d.timer.Stop() {
+		<-d.timer.C
+	}
+	d.timer.Reset(d.quiet)
+}
+
+func (d *debouncer) fire() string {
+	name := d.first
+	d.timer = nil
+	d.C = nil
+	return name
+}
+
+func (d *debouncer) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsevents"
+)
+
+// WatchGlobs watches root for changes to files matching any of patterns,
+// which are relative to root. Once no further matching change has happened
+// for debounce, change is called with the relative path of the first file
+// that changed.
+func WatchGlobs(root string, patterns []string, debounce time.Duration, done <-chan struct{}, change func(string)) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	es := &fsevents.EventStream{
+		Paths:   []string{root},
+		Latency: 100 * time.Millisecond,
+		Flags:   fsevents.FileEvents | fsevents.IgnoreSelf,
+	}
+
+	es.Start()
+
+	defer es.Stop()
+
+	d := debouncer{quiet: debounce}
+	defer d.stop()
+
+	for {
+		select {
+		case events := <-es.Events:
+			for _, ev := range events {
+				// Removing a file or changing its permissions isn't a change
+				// to restart for, such as deleting tmp/restart.txt.
+				if ev.Flags&(fsevents.ItemCreated|fsevents.ItemModified|fsevents.ItemRenamed) == 0 {
+					continue
+				}
+
+				p := ev.Path
+				if !strings.HasPrefix(p, "/") {
+					p = "/" + p
+				}
+
+				rel, err := filepath.Rel(root, p)
+				if err != nil {
+					continue
+				}
+
+				if _, ok := MatchAny(patterns, filepath.ToSlash(rel)); ok {
+					d.changed(filepath.ToSlash(rel))
+				}
+			}
+		case <-d.C:
+			change(d.fire())
+		case <-done:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchGlobs watches root for changes to files matching any of patterns,
+// which are relative to root. Once no further matching change has happened
+// for debounce, change is called with the relative path of the first file
+// that changed.
+func WatchGlobs(root string, patterns []string, debounce time.Duration, done <-chan struct{}, change func(string)) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	defer watcher.Close()
+
+	addDir := func(dir string, recursive bool) {
+		if !recursive {
+			watcher.Add(dir)
+			return
+		}
+
+		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				watcher.Add(p)
+			}
+			return nil
+		})
+	}
+
+	recursiveDirs := map[string]bool{}
+
+	// missing holds the prefix directories that don't exist yet, and
+	// whether they are watched recursively once they do.
+	missing := map[string]bool{}
+
+	addMissing := func() {
+		for dir, recursive := range missing {
+			if _, err := os.Stat(dir); err == nil {
+				delete(missing, dir)
+				addDir(dir, recursive)
+				continue
+			}
+
+			// Watch the nearest parent that exists to see dir being made.
+			parent := filepath.Dir(dir)
+			for len(parent) > len(root) {
+				if _, err := os.Stat(parent); err == nil {
+					break
+				}
+				parent = filepath.Dir(parent)
+			}
+
+			watcher.Add(parent)
+		}
+	}
+
+	for _, pattern := range patterns {
+		prefix, recursive := staticPrefix(pattern)
+		dir := filepath.Join(root, filepath.FromSlash(prefix))
+
+		if recursive {
+			recursiveDirs[dir] = true
+		}
+
+		if _, err := os.Stat(dir); err != nil {
+			missing[dir] = recursive
+			continue
+		}
+
+		addDir(dir, recursive)
+	}
+
+	addMissing()
+
+	underRecursive := func(p string) bool {
+		for dir := range recursiveDirs {
+			if strings.HasPrefix(p, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	d := debouncer{quiet: debounce}
+	defer d.stop()
+
+	for {
+		select {
+		case ev := <-watcher.Events:
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if underRecursive(ev.Name) {
+						addDir(ev.Name, true)
+					}
+
+					if len(missing) > 0 {
+						addMissing()
+					}
+				}
+			}
+
+			// Removing a file or changing its permissions isn't a change to
+			// restart for, such as deleting tmp/restart.txt.
+			if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			rel, err := filepath.Rel(root, ev.Name)
+			if err != nil {
+				continue
+			}
+
+			if _, ok := MatchAny(patterns, filepath.ToSlash(rel)); ok {
+				d.changed(filepath.ToSlash(rel))
+			}
+		case <-watcher.Errors:
+		case <-d.C:
+			change(d.fire())
+		case <-done:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	devtest "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("Gemfile.lock", "Gemfile.lock"))
+	assert.True(t, Match("config/*.rb", "config/puma.rb"))
+	assert.False(t, Match("config/*.rb", "config/initializers/cors.rb"))
+	assert.True(t, Match("config/**/*.rb", "config/puma.rb"))
+	assert.True(t, Match("config/**/*.rb", "config/initializers/cors.rb"))
+	assert.True(t, Match("config/**/*.rb", "config/environments/dev/local.rb"))
+	assert.False(t, Match("config/**/*.rb", "config/locales/en.yml"))
+	assert.False(t, Match("config/**/*.rb", "app/models/user.rb"))
+	assert.True(t, Match("**/.env", ".env"))
+	assert.True(t, Match("**", "anything/at/all"))
+}
+
+func TestStaticPrefix(t *testing.T) {
+	for pattern, expected := range map[string]struct {
+		prefix    string
+		recursive bool
+	}{
+		"Gemfile.lock":         {".", false},
+		"tmp/restart.txt":      {"tmp", false},
+		"config/*.rb":          {"config", false},
+		"config/**/*.rb":       {"config", true},
+		"config/*/routes.rb":   {"config", true},
+		"**/*.rb":              {".", true},
+		"app/views/**/*.erb":   {"app/views", true},
+		"config/initializers/": {"config/initializers", false},
+	} {
+		prefix, recursive := staticPrefix(pattern)
+		assert.Equal(t, expected.prefix, prefix, pattern)
+		assert.Equal(t, expected.recursive, recursive, pattern)
+	}
+}
+
+func TestWatchGlobs_DebouncedChange(t *testing.T) {
+	defer createTmpDir(t)()
+
+	initializers := filepath.Join(tmpDir, "config", "initializers")
+	devtest.MakeDirectoryOrFail(t, initializers)
+	touchFile(t, filepath.Join(tmpDir, "Gemfile.lock"))
+
+	done := make(chan struct{})
+	defer close(done)
+
+	changes := make(chan string, 10)
+
+	go WatchGlobs(tmpDir, []string{"Gemfile.lock", "config/**/*.rb"}, 200*time.Millisecond, done, func(name string) {
+		changes <- name
+	})
+
+	// give the watcher time to start
+	time.Sleep(500 * time.Millisecond)
+
+	touchFile(t, filepath.Join(tmpDir, "README.md"))
+	touchFile(t, filepath.Join(initializers, "cors.rb"))
+	touchFile(t, filepath.Join(tmpDir, "Gemfile.lock"))
+
+	select {
+	case name := <-changes:
+		assert.Equal(t, "config/initializers/cors.rb", name)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "no change detected")
+	}
+
+	select {
+	case name := <-changes:
+		assert.Fail(t, "burst of changes reported more than once", name)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestWatchGlobs_IgnoresRemoveAndChmod(t *testing.T) {
+	defer createTmpDir(t)()
+
+	restartTxt := filepath.Join(tmpDir, "restart.txt")
+	touchFile(t, restartTxt)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	changes := make(chan string, 10)
+
+	go WatchGlobs(tmpDir, []string{"restart.txt"}, 100*time.Millisecond, done, func(name string) {
+		changes <- name
+	})
+
+	// give the watcher time to start
+	time.Sleep(500 * time.Millisecond)
+
+	assert.NoError(t, os.Chmod(restartTxt, 0600))
+	assert.NoError(t, os.Remove(restartTxt))
+
+	select {
+	case name := <-changes:
+		assert.Fail(t, "removing a file reported a change", name)
+	case <-time.After(time.Second):
+	}
+
+	touchFile(t, restartTxt)
+
+	select {
+	case name := <-changes:
+		assert.Equal(t, "restart.txt", name)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "no change detected")
+	}
+}
+
+func TestWatchGlobs_MissingDir(t *testing.T) {
+	defer createTmpDir(t)()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	changes := make(chan string, 10)
+
+	go WatchGlobs(tmpDir, []string{"config/**/*.rb"}, 100*time.Millisecond, done, func(name string) {
+		changes <- name
+	})
+
+	// give the watcher time to start
+	time.Sleep(500 * time.Millisecond)
+
+	initializers := filepath.Join(tmpDir, "config", "initializers")
+	devtest.MakeDirectoryOrFail(t, initializers)
+
+	// and to pick up the new directories
+	time.Sleep(500 * time.Millisecond)
+
+	touchFile(t, filepath.Join(initializers, "cors.rb"))
+
+	select {
+	case name := <-changes:
+		assert.Equal(t, "config/initializers/cors.rb", name)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "no change detected")
+	}
+}